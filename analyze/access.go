@@ -1,15 +1,21 @@
 package analyze
 
 import (
+	"FlapAlerted/bgp/session"
+	"cmp"
 	"net/netip"
+	"slices"
 )
 
 func copyEvent(src *FlapEvent) (event FlapEvent) {
 	// Shallow copy of the struct
 	event = *src
-	// Copy the slice in the struct
+	// Copy the slices in the struct
 	event.RateSecHistory = make([]int, len(src.RateSecHistory))
 	copy(event.RateSecHistory, src.RateSecHistory)
+	event.Sessions = slices.Clone(src.Sessions)
+	event.sessionIndex = nil
+	event.EstablishedSessions = session.GetSessionCount()
 	return
 }
 
@@ -26,8 +32,8 @@ func GetActiveFlapList() (active []FlapEvent, trackedCount int) {
 	aFlap := make([]FlapEvent, 0)
 	activeMapLock.RLock()
 	defer activeMapLock.RUnlock()
-	trackedCount = len(activeMap)
-	for _, src := range activeMap {
+	trackedCount = activeMap.Len()
+	for _, src := range activeMap.All() {
 		event, triggered := copyEventIfTriggered(src)
 		if !triggered {
 			continue
@@ -37,10 +43,68 @@ func GetActiveFlapList() (active []FlapEvent, trackedCount int) {
 	return aFlap, trackedCount
 }
 
+// GetActiveFlapPrefixes returns the prefixes of the active flaps, without copying the events
+func GetActiveFlapPrefixes() []netip.Prefix {
+	prefixes := make([]netip.Prefix, 0)
+	activeMapLock.RLock()
+	defer activeMapLock.RUnlock()
+	for prefix, src := range activeMap.All() {
+		if src.hasTriggered {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes
+}
+
+// GetActiveFlapsWithin returns the active flaps of the prefix and its more-specifics, sorted by prefix
+func GetActiveFlapsWithin(prefix netip.Prefix) []FlapEvent {
+	aFlap := make([]FlapEvent, 0)
+	activeMapLock.RLock()
+	defer activeMapLock.RUnlock()
+	for _, src := range activeMap.MoreSpecifics(prefix) {
+		event, triggered := copyEventIfTriggered(src)
+		if !triggered {
+			continue
+		}
+		aFlap = append(aFlap, event)
+	}
+	return aFlap
+}
+
+// relatedFlaps returns the active flaps covering or covered by the prefix, excluding the prefix itself.
+// activeMapLock must be held.
+func relatedFlaps(prefix netip.Prefix) []netip.Prefix {
+	var related []netip.Prefix
+	add := func(p netip.Prefix, event *FlapEvent) bool {
+		if event.hasTriggered && p != prefix.Masked() {
+			related = append(related, p)
+		}
+		return len(related) < maxRelatedFlaps
+	}
+	for p, event := range activeMap.Covering(prefix) {
+		if !add(p, event) {
+			return related
+		}
+	}
+	for p, event := range activeMap.MoreSpecifics(prefix) {
+		if !add(p, event) {
+			return related
+		}
+	}
+	return related
+}
+
+// copyNotifiedEvent copies an event to be sent in a notification. activeMapLock must be held.
+func copyNotifiedEvent(src *FlapEvent) FlapEvent {
+	event := copyEvent(src)
+	event.RelatedFlaps = relatedFlaps(src.Prefix)
+	return event
+}
+
 func GetActiveFlapPrefix(prefix netip.Prefix) (FlapEvent, bool) {
 	activeMapLock.RLock()
 	defer activeMapLock.RUnlock()
-	src, found := activeMap[prefix]
+	src, found := activeMap.Get(prefix)
 	if !found {
 		return FlapEvent{}, false
 	}
@@ -72,7 +136,7 @@ func copyPeerRateIfActive(src *PeerUpdateRate) (pr PeerUpdateRate, triggered boo
 	return
 }
 
-func calculateAverageRate(pr *PeerUpdateRate) {
+func calculateAverageRate(pr *UpdateRate) {
 	if len(pr.RateSecHistory) == 0 {
 		pr.RateSecAvg = -1
 		return
@@ -100,11 +164,37 @@ func GetPeerRates() []PeerUpdateRate {
 	}()
 
 	for i := range aRates {
-		calculateAverageRate(&aRates[i])
+		calculateAverageRate(&aRates[i].UpdateRate)
 	}
 	return aRates
 }
 
+// GetSessionRates returns the update rates of the sessions that caused path changes
+func GetSessionRates() []SessionUpdateRate {
+	rates := func() []SessionUpdateRate {
+		activeMapLock.RLock()
+		defer activeMapLock.RUnlock()
+		rates := make([]SessionUpdateRate, 0, len(activeMapSession))
+		for _, src := range activeMapSession {
+			if src.RateSec == -1 {
+				continue
+			}
+			rate := *src
+			rate.RateSecHistory = slices.Clone(src.RateSecHistory)
+			rates = append(rates, rate)
+		}
+		return rates
+	}()
+
+	for i := range rates {
+		calculateAverageRate(&rates[i].UpdateRate)
+	}
+	slices.SortFunc(rates, func(a, b SessionUpdateRate) int {
+		return cmp.Or(a.Session.BMPRouter.Compare(b.Session.BMPRouter), a.Session.Remote.Compare(b.Session.Remote), a.Session.RouterID.Compare(b.Session.RouterID))
+	})
+	return rates
+}
+
 func GetActivePeer(asn uint32) (PeerUpdateRate, bool) {
 	f, triggered := func() (PeerUpdateRate, bool) {
 		activeMapLock.RLock()
@@ -120,6 +210,6 @@ func GetActivePeer(asn uint32) (PeerUpdateRate, bool) {
 		return PeerUpdateRate{}, false
 	}
 
-	calculateAverageRate(&f)
+	calculateAverageRate(&f.UpdateRate)
 	return f, true
 }
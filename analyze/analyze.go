@@ -1,8 +1,11 @@
 package analyze
 
 import (
+	"FlapAlerted/bgp/common"
 	"FlapAlerted/bgp/table"
+	"FlapAlerted/bgp/trie"
 	"FlapAlerted/config"
+	"log/slog"
 	"net/netip"
 	"sync"
 	"sync/atomic"
@@ -10,9 +13,11 @@ import (
 )
 
 var (
-	activeMap     = make(map[netip.Prefix]*FlapEvent)
+	activeMap     trie.Trie[*FlapEvent]
 	activeMapPeer = make(map[uint32]*PeerUpdateRate)
-	activeMapLock sync.RWMutex
+	// Update rates by session, which unlike the first ASN of paths also tell apart iBGP sessions
+	activeMapSession = make(map[common.SessionID]*SessionUpdateRate)
+	activeMapLock    sync.RWMutex
 )
 
 var (
@@ -22,11 +27,57 @@ var (
 
 var sendUserDefined atomic.Bool
 
+// Unix time at which the last analysis interval ended, zero if the intervals follow the system clock
+var replayTime atomic.Int64
+
+// currentTime returns the Unix time of the analysis, which follows the timestamps of the MRT files during a replay
+func currentTime() int64 {
+	if t := replayTime.Load(); t != 0 {
+		return t
+	}
+	return time.Now().Unix()
+}
+
+// Set once the limit of tracked peer ASNs or sessions has been reached in the current interval
+var (
+	peerLimitWarned    bool
+	sessionLimitWarned bool
+)
+
+func newUpdateRate() UpdateRate {
+	return UpdateRate{
+		RateSecHistory: make([]int, 0, 1),
+		intervalCount:  1,
+		RateSec:        -1,
+	}
+}
+
 const intervalSec = 60
+
+// Interval is the length of an analysis interval
+const Interval = intervalSec * time.Second
 const maxRateHistory = 60
 const maxPeers = 1000
+const maxSessionsPerEvent = 1000
+
+// Maximum number of related flaps included in a notification
+const maxRelatedFlaps = 50
 
-func RecordPathChanges(pathChan <-chan table.PathChange) (<-chan table.PathChange, <-chan []FlapEventNotification) {
+// recordSessionReset counts the loss of a session for all active events with path changes received on it
+func recordSessionReset(id common.SessionID) {
+	activeMapLock.Lock()
+	defer activeMapLock.Unlock()
+	for _, event := range activeMap.All() {
+		if i, found := event.sessionIndex[id]; found {
+			event.Sessions[i].Resets++
+			event.SessionResets++
+		}
+	}
+}
+
+// RecordPathChanges analyzes path changes in intervals of intervalSec seconds.
+// If clock is nil, intervals are based on the system clock. Otherwise, each value received from clock ends an interval.
+func RecordPathChanges(pathChan <-chan table.PathChange, clock <-chan time.Time) (<-chan table.PathChange, <-chan []FlapEventNotification) {
 	userPathChangeChan := make(chan table.PathChange, 1000)
 	notificationChannel := make(chan []FlapEventNotification, 5)
 
@@ -36,8 +87,12 @@ func RecordPathChanges(pathChan <-chan table.PathChange) (<-chan table.PathChang
 		defer close(userPathChangeChan)
 		defer close(notificationChannel)
 
-		cleanupTicker := time.NewTicker(intervalSec * time.Second)
-		defer cleanupTicker.Stop()
+		replay := clock != nil
+		if !replay {
+			cleanupTicker := time.NewTicker(intervalSec * time.Second)
+			defer cleanupTicker.Stop()
+			clock = cleanupTicker.C
+		}
 		counterMap := make(map[netip.Prefix]uint32)
 		now := time.Now().Unix()
 
@@ -47,8 +102,11 @@ func RecordPathChanges(pathChan <-chan table.PathChange) (<-chan table.PathChang
 			var pathChange table.PathChange
 			var ok bool
 			select {
-			case t := <-cleanupTicker.C:
+			case t := <-clock:
 				now = t.Unix()
+				if replay {
+					replayTime.Store(now)
+				}
 				if lc > 30 {
 					lc = 0
 					counterMap = make(map[netip.Prefix]uint32)
@@ -58,25 +116,21 @@ func RecordPathChanges(pathChan <-chan table.PathChange) (<-chan table.PathChang
 				}
 				activeMapLock.Lock()
 
-				// Peer update rate tracking
+				// Peer and session update rate tracking
 				for asn, peer := range activeMapPeer {
-					if peer.intervalCount == 0 {
-						peer.zeroCount++
-						if peer.zeroCount >= maxRateHistory {
-							delete(activeMapPeer, asn)
-						}
-					} else {
-						peer.zeroCount = 0
-						peer.RateSec = int(peer.intervalCount / intervalSec)
-						peer.intervalCount = 0
-						peer.RateSecHistory = append(peer.RateSecHistory, peer.RateSec)
-						if len(peer.RateSecHistory) > maxRateHistory {
-							peer.RateSecHistory = peer.RateSecHistory[1:]
-						}
+					if peer.endInterval() {
+						delete(activeMapPeer, asn)
 					}
 				}
+				for id, s := range activeMapSession {
+					if s.endInterval() {
+						delete(activeMapSession, id)
+					}
+				}
+				peerLimitWarned = false
+				sessionLimitWarned = false
 
-				for prefix, event := range activeMap {
+				for prefix, event := range activeMap.All() {
 					intervalCount := event.TotalPathChanges - event.lastIntervalCount
 					event.RateSec = int(intervalCount / intervalSec)
 					event.lastIntervalCount = event.TotalPathChanges
@@ -90,11 +144,11 @@ func RecordPathChanges(pathChan <-chan table.PathChange) (<-chan table.PathChang
 						if event.hasTriggered {
 							if intervalCount <= uint64(config.GlobalConf.ExpiryRouteChangeCounter) {
 								if event.underThresholdCount == config.GlobalConf.UnderThresholdTarget {
-									delete(activeMap, prefix)
+									activeMap.Delete(prefix)
 									if len(notificationsBatch) <= 50 {
 										notificationsBatch = append(notificationsBatch, FlapEventNotification{
 											IsStart: false,
-											Event:   copyEvent(event),
+											Event:   copyNotifiedEvent(event),
 										})
 									}
 								} else {
@@ -102,7 +156,7 @@ func RecordPathChanges(pathChan <-chan table.PathChange) (<-chan table.PathChang
 								}
 							}
 						} else {
-							delete(activeMap, prefix)
+							activeMap.Delete(prefix)
 						}
 					} else {
 						event.underThresholdCount = 0
@@ -112,13 +166,23 @@ func RecordPathChanges(pathChan <-chan table.PathChange) (<-chan table.PathChang
 							if len(notificationsBatch) <= 50 {
 								notificationsBatch = append(notificationsBatch, FlapEventNotification{
 									IsStart: true,
-									Event:   copyEvent(event),
+									Event:   copyNotifiedEvent(event),
 								})
 							}
 						} else {
 							event.overThresholdCount++
 						}
 					}
+
+					if _, active := activeMap.Get(prefix); active && event.hasTriggered && !event.routeLeakNotified && event.PossibleRouteLeakChanges != 0 {
+						event.routeLeakNotified = true
+						if len(notificationsBatch) <= 50 {
+							notificationsBatch = append(notificationsBatch, FlapEventNotification{
+								IsRouteLeak: true,
+								Event:       copyNotifiedEvent(event),
+							})
+						}
+					}
 				}
 				activeMapLock.Unlock()
 				if len(notificationsBatch) > 0 {
@@ -135,6 +199,16 @@ func RecordPathChanges(pathChan <-chan table.PathChange) (<-chan table.PathChang
 				}
 			}
 
+			if pathChange.IsSessionReset {
+				recordSessionReset(pathChange.Session)
+				continue
+			}
+
+			if pathChange.IsInitialDump {
+				// Changes during the initial table dump of a session are not caused by prefix instability
+				continue
+			}
+
 			if sendUserDefined.Load() {
 				select {
 				case userPathChangeChan <- pathChange:
@@ -145,16 +219,20 @@ func RecordPathChanges(pathChan <-chan table.PathChange) (<-chan table.PathChang
 			GlobalTotalRouteChangeCounter.Add(1)
 
 			activeMapLock.Lock()
-			if val, exists := activeMap[pathChange.Prefix]; exists {
+			if val, exists := activeMap.Get(pathChange.Prefix); exists {
 				incrementUint64(&val.TotalPathChanges)
-				val.PathHistory.record(pathChange.OldPath, pathChange.IsWithdrawal)
+				val.PathHistory.record(pathChange.OldPath, pathChange.IsWithdrawal, pathChange.IsAttributeChange)
+				val.recordSession(pathChange.Session)
+				if pathChange.IsPossibleRouteLeak {
+					incrementUint64(&val.PossibleRouteLeakChanges)
+				}
 				if val.hasTriggered {
 					GlobalListedRouteChangeCounter.Add(1)
 				}
 			} else {
 				if counterMap[pathChange.Prefix] == uint32(config.GlobalConf.RouteChangeCounter) {
-					if len(activeMap) <= config.GlobalConf.MaxActivePrefixes {
-						activeMap[pathChange.Prefix] = &FlapEvent{
+					if activeMap.Len() <= config.GlobalConf.MaxActivePrefixes {
+						event := &FlapEvent{
 							Prefix:             pathChange.Prefix,
 							PathHistory:        newPathTracker(config.GlobalConf.MaxPathHistory),
 							TotalPathChanges:   uint64(counterMap[pathChange.Prefix]) + 1,
@@ -165,6 +243,11 @@ func RecordPathChanges(pathChan <-chan table.PathChange) (<-chan table.PathChang
 							// Special case for the 'display all route changes' mode
 							hasTriggered: config.GlobalConf.RouteChangeCounter == 0,
 						}
+						if pathChange.IsPossibleRouteLeak {
+							event.PossibleRouteLeakChanges = 1
+						}
+						event.recordSession(pathChange.Session)
+						activeMap.Insert(pathChange.Prefix, event)
 					}
 				} else {
 					counterMap[pathChange.Prefix]++
@@ -172,20 +255,40 @@ func RecordPathChanges(pathChan <-chan table.PathChange) (<-chan table.PathChang
 			}
 
 			// Peer update rate tracking
-			if len(pathChange.OldPath) != 0 {
-				peerASN := pathChange.OldPath[0]
+			// Announcements are counted for the neighbor ASN of the new path, withdrawals for the one of the old path
+			peerPath := pathChange.NewPath
+			if pathChange.IsWithdrawal {
+				peerPath = pathChange.OldPath
+			}
+			if len(peerPath) != 0 {
+				peerASN := peerPath[0]
 				if val, exists := activeMapPeer[peerASN]; exists {
 					val.intervalCount++
 				} else {
 					if len(activeMapPeer) <= maxPeers {
 						activeMapPeer[peerASN] = &PeerUpdateRate{
-							PeerASN:        peerASN,
-							RateSecHistory: make([]int, 0, 1),
-							intervalCount:  1,
-							zeroCount:      0,
-							RateSec:        -1,
+							PeerASN:    peerASN,
+							UpdateRate: newUpdateRate(),
 						}
+					} else if !peerLimitWarned {
+						peerLimitWarned = true
+						slog.Warn("Maximum number of peers reached, update rates of further peer ASNs are not tracked", "limit", maxPeers)
+					}
+				}
+			}
+
+			// Session update rate tracking
+			if val, exists := activeMapSession[pathChange.Session]; exists {
+				val.intervalCount++
+			} else {
+				if len(activeMapSession) <= maxPeers {
+					activeMapSession[pathChange.Session] = &SessionUpdateRate{
+						Session:    pathChange.Session,
+						UpdateRate: newUpdateRate(),
 					}
+				} else if !sessionLimitWarned {
+					sessionLimitWarned = true
+					slog.Warn("Maximum number of peers reached, update rates of further sessions are not tracked", "limit", maxPeers)
 				}
 			}
 
@@ -1,6 +1,7 @@
 package analyze
 
 import (
+	"FlapAlerted/bgp/common"
 	"encoding/json"
 	"net/netip"
 )
@@ -10,6 +11,21 @@ type FlapEvent struct {
 	Prefix           netip.Prefix
 	PathHistory      *PathTracker
 	TotalPathChanges uint64
+	// Path changes involving a path classified as a possible route leak (RFC 9234)
+	PossibleRouteLeakChanges uint64
+	// Other active flaps of covering or more-specific prefixes, only set in notifications
+	RelatedFlaps []netip.Prefix `json:",omitempty"`
+
+	// ===== Session attribution =====
+	// Path changes by the session they were received on, counted once the prefix is tracked
+	Sessions     []SessionPathChanges
+	sessionIndex map[common.SessionID]int
+	// Number of times a session with path changes of the event was lost. Path changes coinciding with
+	// session resets may be caused by the loss of a feed rather than by prefix instability.
+	SessionResets uint64
+	// Number of established sessions when the event was copied. A flap seen by only some of them is likely
+	// caused close to these sessions, a flap seen by all of them rather by the origin.
+	EstablishedSessions int
 
 	// ===== Rate calculation =====
 	RateSecHistory    []int
@@ -21,11 +37,38 @@ type FlapEvent struct {
 	overThresholdCount  int
 	underThresholdCount int
 	hasTriggered        bool
+	routeLeakNotified   bool
+}
+
+// SessionPathChanges counts the path changes of a flap event received on a session
+type SessionPathChanges struct {
+	Session     common.SessionID
+	PathChanges uint64
+	// Number of times the session was lost while the event was active
+	Resets uint64
+}
+
+// recordSession counts a path change received on the session
+func (e *FlapEvent) recordSession(id common.SessionID) {
+	if i, found := e.sessionIndex[id]; found {
+		incrementUint64(&e.Sessions[i].PathChanges)
+		return
+	}
+	if len(e.Sessions) >= maxSessionsPerEvent {
+		return
+	}
+	if e.sessionIndex == nil {
+		e.sessionIndex = make(map[common.SessionID]int)
+	}
+	e.sessionIndex[id] = len(e.Sessions)
+	e.Sessions = append(e.Sessions, SessionPathChanges{Session: id, PathChanges: 1})
 }
 
 type FlapEventNotification struct {
 	Event   FlapEvent
 	IsStart bool
+	// The event involves possible route leaks, sent once per event in addition to the start and end notifications
+	IsRouteLeak bool
 }
 
 type FlapEventNoPaths FlapEvent
@@ -44,14 +87,39 @@ func (fe *FlapEventNoPaths) MarshalJSON() ([]byte, error) {
 
 // ====================
 
-type PeerUpdateRate struct {
-	// ===== Core data =====
-	PeerASN uint32
-
-	// ===== Rate calculation =====
+// UpdateRate is the rate of path changes per second over the last maxRateHistory intervals
+type UpdateRate struct {
 	RateSecHistory []int
 	intervalCount  uint32
 	zeroCount      int
 	RateSec        int
 	RateSecAvg     float64 // Calculated on demand
 }
+
+// endInterval records the rate of the interval. Returns true if no path changes have been counted for maxRateHistory intervals.
+func (r *UpdateRate) endInterval() (expired bool) {
+	if r.intervalCount == 0 {
+		r.zeroCount++
+		return r.zeroCount >= maxRateHistory
+	}
+	r.zeroCount = 0
+	r.RateSec = int(r.intervalCount / intervalSec)
+	r.intervalCount = 0
+	r.RateSecHistory = append(r.RateSecHistory, r.RateSec)
+	if len(r.RateSecHistory) > maxRateHistory {
+		r.RateSecHistory = r.RateSecHistory[1:]
+	}
+	return false
+}
+
+// PeerUpdateRate is the update rate of a neighbor ASN, taken from the first ASN of the announced or withdrawn paths
+type PeerUpdateRate struct {
+	PeerASN uint32
+	UpdateRate
+}
+
+// SessionUpdateRate is the update rate of a BGP session
+type SessionUpdateRate struct {
+	Session common.SessionID
+	UpdateRate
+}
@@ -3,14 +3,14 @@ package analyze
 import (
 	"FlapAlerted/bgp/common"
 	"container/list"
-	"encoding/binary"
 	"encoding/json"
 	"math"
 	"sync"
 )
 
 type PathTracker struct {
-	paths map[string]*list.Element
+	// Paths are interned, equal paths are keyed by the same pointer
+	paths map[*uint32]*list.Element
 	order *list.List
 	limit int
 	lock  sync.RWMutex
@@ -20,29 +20,35 @@ type PathInfo struct {
 	Path              common.AsPath
 	AnnouncementCount uint64 `json:"ac"`
 	WithdrawalCount   uint64 `json:"wc"`
+	// Announcements that only changed attributes other than the AS path. Included in AnnouncementCount.
+	AttributeChangeCount uint64 `json:"atc,omitempty"`
 }
 
 type pathEntry struct {
-	key   string
+	key   *uint32
 	info  *PathInfo
 	ticks int
 }
 
-func (pt *PathTracker) record(path common.AsPath, isWithdrawal bool) {
+func (pt *PathTracker) record(path common.AsPath, isWithdrawal, isAttributeChange bool) {
 	if pt.limit == 0 {
 		return
 	}
-	key := pathToKey(path)
+	path = common.InternAsPath(path)
+	key := pathKey(path)
 
 	pt.lock.Lock()
 	defer pt.lock.Unlock()
 
-	if elem, exists := pt.paths[string(key)]; exists {
+	if elem, exists := pt.paths[key]; exists {
 		entry := elem.Value.(*pathEntry)
 		if isWithdrawal {
 			incrementUint64(&entry.info.WithdrawalCount)
 		} else {
 			incrementUint64(&entry.info.AnnouncementCount)
+			if isAttributeChange {
+				incrementUint64(&entry.info.AttributeChangeCount)
+			}
 		}
 
 		entry.ticks = 0
@@ -61,23 +67,24 @@ func (pt *PathTracker) record(path common.AsPath, isWithdrawal bool) {
 		pathInfoEntry.WithdrawalCount = 1
 	} else {
 		pathInfoEntry.AnnouncementCount = 1
+		if isAttributeChange {
+			pathInfoEntry.AttributeChangeCount = 1
+		}
 	}
 
 	elem := pt.order.PushBack(&pathEntry{
-		key:  string(key),
+		key:  key,
 		info: pathInfoEntry,
 	})
-	pt.paths[string(key)] = elem
+	pt.paths[key] = elem
 }
-func pathToKey(p common.AsPath) []byte {
+
+// pathKey identifies an interned path by its backing array
+func pathKey(p common.AsPath) *uint32 {
 	if len(p) == 0 {
 		return nil
 	}
-	buf := make([]byte, len(p)*4)
-	for i, v := range p {
-		binary.LittleEndian.PutUint32(buf[i*4:], v)
-	}
-	return buf
+	return &p[0]
 }
 
 func (pt *PathTracker) deleteLeastValuable() {
@@ -130,7 +137,7 @@ func (pt *PathTracker) All() func(func(*PathInfo) bool) {
 
 func newPathTracker(limit int) *PathTracker {
 	return &PathTracker{
-		paths: make(map[string]*list.Element),
+		paths: make(map[*uint32]*list.Element),
 		order: list.New(),
 		limit: limit,
 	}
@@ -160,7 +167,7 @@ func (pt *PathTracker) UnmarshalJSON(data []byte) error {
 	pt.lock.Lock()
 	defer pt.lock.Unlock()
 
-	pt.paths = make(map[string]*list.Element)
+	pt.paths = make(map[*uint32]*list.Element)
 	pt.order = list.New()
 
 	if pt.limit > 0 && len(entries) > pt.limit {
@@ -168,7 +175,11 @@ func (pt *PathTracker) UnmarshalJSON(data []byte) error {
 	}
 
 	for _, info := range entries {
-		key := string(pathToKey(info.Path))
+		info.Path = common.InternAsPath(info.Path)
+		key := pathKey(info.Path)
+		if _, duplicate := pt.paths[key]; duplicate {
+			continue
+		}
 		elem := pt.order.PushBack(&pathEntry{
 			key:  key,
 			info: info,
@@ -5,7 +5,6 @@ import (
 	"FlapAlerted/config"
 	"net/netip"
 	"sync"
-	"time"
 )
 
 var (
@@ -22,7 +21,7 @@ func RecordUserDefinedMonitors(userPathChangeChan <-chan table.PathChange) {
 		userDefinedMapLock.Lock()
 		if val, exists := userDefinedMap[pathChange.Prefix]; exists {
 			incrementUint64(&val.TotalPathChanges)
-			val.PathHistory.record(pathChange.OldPath, pathChange.IsWithdrawal)
+			val.PathHistory.record(pathChange.OldPath, pathChange.IsWithdrawal, pathChange.IsAttributeChange)
 		}
 		userDefinedMapLock.Unlock()
 	}
@@ -39,7 +38,7 @@ func AddUserDefinedPrefix(prefix netip.Prefix) {
 			TotalPathChanges: 0,
 			RateSecHistory:   []int{},
 			hasTriggered:     true,
-			FirstSeen:        time.Now().Unix(),
+			FirstSeen:        currentTime(),
 		}
 		sendUserDefined.Store(true)
 	}
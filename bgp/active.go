@@ -0,0 +1,79 @@
+package bgp
+
+import (
+	"FlapAlerted/bgp/session"
+	"FlapAlerted/bgp/table"
+	"FlapAlerted/config"
+	"context"
+	"log/slog"
+	"net"
+	"net/netip"
+	"time"
+)
+
+const (
+	maxIdleHoldTime = 5 * time.Minute
+	// Sessions that stay established for at least this long reset the idle hold backoff
+	stableSessionTime = 10 * time.Minute
+	connectTimeout    = 15 * time.Second
+)
+
+// connectNeighbor actively establishes and re-establishes the BGP session to a configured neighbor
+func connectNeighbor(ctx context.Context, neighbor config.Neighbor, pathChangeChan chan table.PathChange) {
+	remote := netip.AddrPortFrom(neighbor.Address.Addr(), neighbor.Port)
+	logger := slog.With("neighbor", remote)
+	dialer := net.Dialer{Timeout: connectTimeout, Control: dialerControl(neighbor)}
+	idleHoldTime := neighbor.IdleHoldTime
+
+	wait := func(d time.Duration) bool {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timer.C:
+			return true
+		}
+	}
+
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		// The neighbor might have connected to us in the meantime or have been blocked
+		if session.HasSessionFrom(neighbor.Address.Addr()) || session.IsBlocked(neighbor.Address.Addr()) {
+			if !wait(neighbor.ConnectRetry) {
+				return
+			}
+			continue
+		}
+
+		conn, err := dialer.DialContext(ctx, "tcp", remote.String())
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logger.Warn("Failed to connect to neighbor", "error", err, "retry_in", neighbor.ConnectRetry)
+			if !wait(neighbor.ConnectRetry) {
+				return
+			}
+			continue
+		}
+
+		start := time.Now()
+		established := handleConnection(ctx, conn, pathChangeChan, true)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if established && time.Since(start) >= stableSessionTime {
+			idleHoldTime = neighbor.IdleHoldTime
+		}
+		logger.Info("Waiting before reconnecting to neighbor", "idle_hold_time", idleHoldTime)
+		if !wait(idleHoldTime) {
+			return
+		}
+		idleHoldTime = min(idleHoldTime*2, max(maxIdleHoldTime, neighbor.IdleHoldTime))
+	}
+}
@@ -0,0 +1,265 @@
+package bgp
+
+import (
+	"FlapAlerted/bgp/common"
+	"FlapAlerted/bgp/update"
+	"FlapAlerted/config"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Interval in which the announced prefixes are updated from their source
+const announceInterval = 5 * time.Second
+
+// Local preference of the routes announced to iBGP peers
+const announceLocalPref = 100
+
+// announcedPrefixes is the set of prefixes announced to the sessions that have announcing enabled
+type announcedPrefixes struct {
+	lock sync.RWMutex
+	// Replaced instead of modified, so that it can be read without holding the lock
+	prefixes map[netip.Prefix]struct{}
+	// Closed once the prefixes have changed
+	changed chan struct{}
+}
+
+// Nil if announcing is disabled
+var announced *announcedPrefixes
+
+func newAnnouncedPrefixes() *announcedPrefixes {
+	return &announcedPrefixes{
+		prefixes: make(map[netip.Prefix]struct{}),
+		changed:  make(chan struct{}),
+	}
+}
+
+// run updates the prefixes from the source until the context is canceled
+func (a *announcedPrefixes) run(ctx context.Context, source func() []netip.Prefix) {
+	ticker := time.NewTicker(announceInterval)
+	defer ticker.Stop()
+	for {
+		a.update(source())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (a *announcedPrefixes) update(prefixes []netip.Prefix) {
+	current := make(map[netip.Prefix]struct{}, len(prefixes))
+	for _, p := range prefixes {
+		current[p.Masked()] = struct{}{}
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if len(current) == len(a.prefixes) {
+		unchanged := true
+		for p := range current {
+			if _, found := a.prefixes[p]; !found {
+				unchanged = false
+				break
+			}
+		}
+		if unchanged {
+			return
+		}
+	}
+	a.prefixes = current
+	close(a.changed)
+	a.changed = make(chan struct{})
+}
+
+// get returns the current prefixes, which must not be modified, and a channel closed once they have changed
+func (a *announcedPrefixes) get() (map[netip.Prefix]struct{}, <-chan struct{}) {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	return a.prefixes, a.changed
+}
+
+// announceHandler announces the prefixes to the peer and withdraws them once they are no longer announced
+func announceHandler(ctx context.Context, ctxCancel context.CancelCauseFunc, wg *sync.WaitGroup, logger *slog.Logger, conn net.Conn, session *common.LocalSession) {
+	if announced == nil || !session.Announce {
+		return
+	}
+	afis := announceAFIs(session)
+	if len(afis) == 0 {
+		logger.Warn("Not announcing active flaps, no address family can be announced to the peer")
+		return
+	}
+	maxLength := update.MaxMessageLength
+	if session.HasExtendedMessages {
+		maxLength = update.MaxExtendedMessageLength
+	}
+	asPath, attributes := announceAttributesOf(session)
+	logger.Info("Announcing active flaps", "families", afis, "next_hop", attributes.NextHop)
+
+	wg.Go(func() {
+		// Unblocks a write to a peer that stopped reading, also if no hold time was negotiated.
+		// Like in the keepalive handler, the deadline leaves time for a NOTIFICATION to be sent.
+		stop := context.AfterFunc(ctx, func() {
+			if err := conn.SetWriteDeadline(time.Now().Add(3 * time.Second)); err != nil {
+				_ = conn.Close()
+			}
+		})
+		defer stop()
+
+		sent := make(map[netip.Prefix]struct{})
+		endOfRIB := false
+		for {
+			current, changed := announced.get()
+			var announce, withdraw []netip.Prefix
+			for p := range current {
+				if _, found := sent[p]; !found && slices.Contains(afis, afiOf(p)) {
+					announce = append(announce, p)
+				}
+			}
+			for p := range sent {
+				if _, found := current[p]; !found {
+					withdraw = append(withdraw, p)
+				}
+			}
+
+			messages, err := announceMessages(afis, announce, withdraw, asPath, attributes, maxLength)
+			if err != nil {
+				logger.Error("Failed to encode UPDATE messages", "error", err)
+				ctxCancel(err)
+				return
+			}
+			if !endOfRIB {
+				for _, afi := range afis {
+					eor, err := update.GetEndOfRIB(afi)
+					if err != nil {
+						logger.Error("Failed to encode End-of-RIB marker", "error", err)
+						ctxCancel(err)
+						return
+					}
+					messages = append(messages, eor...)
+				}
+				endOfRIB = true
+			}
+			if len(messages) != 0 {
+				// A single write, so that the messages are not interleaved with keepalives
+				if _, err := conn.Write(messages); err != nil {
+					logger.Debug("Error sending UPDATE messages", "error", err)
+					ctxCancel(wrapConnectionLost(err))
+					return
+				}
+			}
+			for _, p := range announce {
+				sent[p] = struct{}{}
+			}
+			for _, p := range withdraw {
+				delete(sent, p)
+			}
+			if len(announce) != 0 || len(withdraw) != 0 {
+				logger.Debug("Updated announced flaps", "announced", len(announce), "withdrawn", len(withdraw), "total", len(sent))
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-changed:
+			}
+		}
+	})
+}
+
+// announceMessages returns the encoded UPDATE messages withdrawing and announcing the prefixes
+func announceMessages(afis []common.AFI, announce, withdraw []netip.Prefix, asPath common.AsPath, attributes *common.PathAttributes, maxLength int) ([]byte, error) {
+	slices.SortFunc(announce, netip.Prefix.Compare)
+	slices.SortFunc(withdraw, netip.Prefix.Compare)
+	var result []byte
+	for _, afi := range afis {
+		prefixes := prefixesOf(withdraw, afi)
+		if len(prefixes) != 0 {
+			messages, err := update.GetWithdraw(afi, prefixes, maxLength)
+			if err != nil {
+				return nil, fmt.Errorf("error marshalling withdrawals: %w", err)
+			}
+			for _, m := range messages {
+				result = append(result, m...)
+			}
+		}
+		prefixes = prefixesOf(announce, afi)
+		if len(prefixes) != 0 {
+			messages, err := update.GetAnnounce(afi, prefixes, asPath, attributes, maxLength)
+			if err != nil {
+				return nil, fmt.Errorf("error marshalling announcements: %w", err)
+			}
+			for _, m := range messages {
+				result = append(result, m...)
+			}
+		}
+	}
+	return result, nil
+}
+
+// announceAFIs returns the address families that routes can be announced for on the session
+func announceAFIs(session *common.LocalSession) []common.AFI {
+	var afis []common.AFI
+	if !session.LocalAddress.IsValid() {
+		return afis
+	}
+	for _, afi := range session.MultiProtocolAFIs {
+		// IPv4 routes over IPv6 sessions require an extended next hop (RFC 8950)
+		if afi == common.AFI4 && !session.LocalAddress.Is4() && !session.HasExtendedNextHopV4 {
+			continue
+		}
+		if !slices.Contains(afis, afi) {
+			afis = append(afis, afi)
+		}
+	}
+	return afis
+}
+
+// announceAttributesOf returns the AS path and attributes of the routes announced on the session
+func announceAttributesOf(session *common.LocalSession) (common.AsPath, *common.PathAttributes) {
+	attributes := &common.PathAttributes{
+		Origin:           common.OriginIGP,
+		NextHop:          session.LocalAddress,
+		Communities:      config.GlobalConf.AnnounceCommunities,
+		LargeCommunities: config.GlobalConf.AnnounceLargeCommunities,
+	}
+	var asPath common.AsPath
+	if session.RemoteAsn == session.Asn {
+		attributes.LocalPref = announceLocalPref
+		attributes.HasLocalPref = true
+	} else {
+		asPath = common.AsPath{session.Asn}
+	}
+	if session.OwnRole != nil {
+		switch *session.OwnRole {
+		case common.RoleProvider, common.RolePeer, common.RoleRouteServer:
+			// Routes sent to customers, peers and route server clients carry the own ASN (RFC 9234 section 5)
+			attributes.OnlyToCustomer = session.Asn
+			attributes.HasOnlyToCustomer = true
+		}
+	}
+	return asPath, attributes
+}
+
+func afiOf(p netip.Prefix) common.AFI {
+	if p.Addr().Is4() {
+		return common.AFI4
+	}
+	return common.AFI6
+}
+
+func prefixesOf(prefixes []netip.Prefix, afi common.AFI) []netip.Prefix {
+	var result []netip.Prefix
+	for _, p := range prefixes {
+		if afiOf(p) == afi {
+			result = append(result, p)
+		}
+	}
+	return result
+}
@@ -2,10 +2,12 @@ package bgp
 
 import (
 	"FlapAlerted/bgp/common"
+	"FlapAlerted/bgp/mrt"
 	"FlapAlerted/bgp/notification"
 	"FlapAlerted/bgp/open"
 	"FlapAlerted/bgp/table"
 	"FlapAlerted/bgp/update"
+	"FlapAlerted/config"
 	"bufio"
 	"context"
 	"errors"
@@ -17,13 +19,15 @@ import (
 	"time"
 )
 
-func newBGPConnection(ctx context.Context, logger *slog.Logger, conn net.Conn, session *common.LocalSession) (err error) {
+const ownRestartTime = 120
+
+func newBGPConnection(ctx context.Context, logger *slog.Logger, conn net.Conn, session *common.LocalSession, openConfirm func() error) (err error) {
 	stop := context.AfterFunc(ctx, func() {
 		_ = conn.Close()
 	})
 	defer stop()
 
-	const ownHoldTime = 240
+	ownHoldTime := session.OwnHoldTime
 	err = conn.SetDeadline(time.Now().Add(15 * time.Second))
 	if err != nil {
 		logger.Warn("Failed to set connection deadline", "error", err)
@@ -55,7 +59,14 @@ func newBGPConnection(ctx context.Context, logger *slog.Logger, conn net.Conn, s
 		{
 			CapabilityCode: open.CapabilityCodeHostname,
 			CapabilityValue: open.HostnameCapability{
-				Hostname: "flapalerted",
+				Hostname: session.OwnHostname,
+			},
+		},
+		{
+			// No address families are listed as the forwarding state of announced routes is not preserved
+			CapabilityCode: open.CapabilityCodeGracefulRestart,
+			CapabilityValue: open.GracefulRestartCapability{
+				RestartTime: ownRestartTime,
 			},
 		},
 		{
@@ -88,6 +99,13 @@ func newBGPConnection(ctx context.Context, logger *slog.Logger, conn net.Conn, s
 		})
 	}
 
+	if session.OwnRole != nil {
+		myCapabilities = append(myCapabilities, open.CapabilityOptionalParameter{
+			CapabilityCode:  open.CapabilityCodeRole,
+			CapabilityValue: open.RoleCapability{Role: *session.OwnRole},
+		})
+	}
+
 	openMessage, err := open.GetOpen(ownHoldTime, session.OwnRouterID, myCapabilities...)
 	if err != nil {
 		return fmt.Errorf("error marshalling OPEN message: %w", err)
@@ -102,6 +120,7 @@ func newBGPConnection(ctx context.Context, logger *slog.Logger, conn net.Conn, s
 	if err != nil {
 		return fmt.Errorf("error reading OPEN message from peer: %w", err)
 	}
+	session.Stats.CountMessage(msg.Header)
 	if msg.Header.BgpType != common.MsgOpen {
 		if msg.Header.BgpType == common.MsgNotification {
 			if notificationMsg, err := notification.ParseMsgNotification(r); err == nil {
@@ -125,6 +144,7 @@ func newBGPConnection(ctx context.Context, logger *slog.Logger, conn net.Conn, s
 	hasAddPathIPv4 := false
 	hasAddPathIPv6 := false
 	hasFourByteAsn := false
+	roleMismatch := false
 	var remoteASN uint32 = 0
 	for _, p := range msg.Body.(open.Msg).OptionalParameters {
 		for _, t := range p.ParameterValue.(open.CapabilityList).List {
@@ -154,7 +174,10 @@ func newBGPConnection(ctx context.Context, logger *slog.Logger, conn net.Conn, s
 					hasMultiProtocolIPv4 = true
 				case common.AFI6:
 					hasMultiProtocolIPv6 = true
+				default:
+					continue
 				}
+				session.MultiProtocolAFIs = append(session.MultiProtocolAFIs, v.AFI)
 			case open.HostnameCapability:
 				session.RemoteHostname = v.String()
 			case open.ExtendedNextHopCapabilityList:
@@ -165,6 +188,24 @@ func newBGPConnection(ctx context.Context, logger *slog.Logger, conn net.Conn, s
 				}
 			case open.ExtendedMessageCapability:
 				session.HasExtendedMessages = true
+			case open.RoleCapability:
+				if session.RemoteRole != nil && *session.RemoteRole != v.Role {
+					// Multiple differing roles
+					roleMismatch = true
+				}
+				session.RemoteRole = &v.Role
+			case open.GracefulRestartCapability:
+				session.HasGracefulRestart = true
+				session.RestartTime = int(v.RestartTime)
+				for _, tuple := range v.Tuples {
+					if tuple.SAFI != common.UNICAST {
+						continue
+					}
+					session.GracefulRestartAFIs = append(session.GracefulRestartAFIs, tuple.AFI)
+					if tuple.ForwardingStatePreserved {
+						session.ForwardingStateAFIs = append(session.ForwardingStateAFIs, tuple.AFI)
+					}
+				}
 			}
 		}
 	}
@@ -192,11 +233,28 @@ func newBGPConnection(ctx context.Context, logger *slog.Logger, conn net.Conn, s
 		}
 		return fmt.Errorf("four byte ASNs not supported by peer")
 	}
-	if remoteASN != session.Asn {
+	if session.ExpectedRemoteAsn != 0 && remoteASN != session.ExpectedRemoteAsn {
 		if nMsg, err := notification.GetNotification(notification.OpenMessageError, notification.OpenBadPeerAS, []byte{}); err == nil {
 			_, _ = conn.Write(nMsg)
 		}
-		return fmt.Errorf("remote ASN (%d) does not match the set asn (%d)", remoteASN, session.Asn)
+		return fmt.Errorf("remote ASN (%d) does not match the expected asn (%d)", remoteASN, session.ExpectedRemoteAsn)
+	}
+	session.RemoteAsn = remoteASN
+
+	if session.OwnRole != nil {
+		expectedRole, _ := session.OwnRole.Counterpart()
+		if session.RemoteRole == nil {
+			// The configured relationship is assumed if the peer does not advertise its role
+			session.RemoteRole = &expectedRole
+		} else if *session.RemoteRole != expectedRole {
+			roleMismatch = true
+		}
+	}
+	if roleMismatch {
+		if nMsg, err := notification.GetNotification(notification.OpenMessageError, notification.OpenRoleMismatch, []byte{}); err == nil {
+			_, _ = conn.Write(nMsg)
+		}
+		return fmt.Errorf("peer role (%s) does not match the own role (%s)", session.RemoteRole, session.OwnRole)
 	}
 
 	if !hasMultiProtocolIPv4 && !hasMultiProtocolIPv6 {
@@ -213,6 +271,13 @@ func newBGPConnection(ctx context.Context, logger *slog.Logger, conn net.Conn, s
 		return fmt.Errorf("addPath is not supported by peer")
 	}
 
+	if err := openConfirm(); err != nil {
+		if nMsg, err := notification.GetNotification(notification.Cease, notification.CeaseConnectionCollisionResolution, []byte{}); err == nil {
+			_, _ = conn.Write(nMsg)
+		}
+		return err
+	}
+
 	keepAliveBytes, _ := GetKeepAlive()
 	_, err = conn.Write(keepAliveBytes)
 	if err != nil {
@@ -223,6 +288,7 @@ func newBGPConnection(ctx context.Context, logger *slog.Logger, conn net.Conn, s
 	if err != nil {
 		return fmt.Errorf("error reading KEEPALIVE message from peer: %w", err)
 	}
+	session.Stats.CountMessage(msg.Header)
 	if msg.Header.BgpType != common.MsgKeepAlive {
 		if msg.Header.BgpType == common.MsgNotification {
 			notificationMsg, err := notification.ParseMsgNotification(r)
@@ -250,6 +316,7 @@ func handleEstablished(ctx context.Context, ctxCancel context.CancelCauseFunc, c
 	var wg sync.WaitGroup
 	defer wg.Wait()
 	keepAliveHandler(ctx, ctxCancel, &wg, logger, keepAliveChan, conn, session.ApplicableHoldTime)
+	announceHandler(ctx, ctxCancel, &wg, logger, conn, session)
 
 	err = handleMessages(ctx, logger, conn, session, updateChannel, keepAliveChan, session.HasExtendedMessages)
 	if err != nil {
@@ -257,6 +324,8 @@ func handleEstablished(ctx context.Context, ctxCancel context.CancelCauseFunc, c
 		defer time.Sleep(1 * time.Second)
 
 		ctxCause := context.Cause(ctx)
+		// Stop the keepalive handler
+		defer ctxCancel(err)
 		if ctxCause != nil {
 			// Context has been canceled
 			if errors.Is(ctxCause, notification.ErrImportLimit) {
@@ -264,23 +333,45 @@ func handleEstablished(ctx context.Context, ctxCancel context.CancelCauseFunc, c
 					_, _ = conn.Write(nMsg)
 				}
 			} else if errors.Is(ctxCause, notification.ErrAdministrativeShutdown) {
-				if nMsg, err := notification.GetNotification(notification.Cease, notification.CeaseAdministrativeShutdown, []byte{}); err == nil {
+				subCode, message := notification.CeaseAdministrativeShutdown, config.GlobalConf.BgpShutdownMessage
+				if reset, ok := errors.AsType[*notification.AdministrativeReset](ctxCause); ok {
+					subCode, message = notification.CeaseAdministrativeReset, reset.Message
+				}
+				data := notification.ShutdownCommunicationData(message)
+				if nMsg, err := notification.GetNotification(notification.Cease, subCode, data); err == nil {
 					_, _ = conn.Write(nMsg)
 				}
 			} else if errors.Is(ctxCause, notification.ErrHoldTimeExpired) {
 				if nMsg, err := notification.GetNotification(notification.HoldTimerExpiredError, 0, []byte{}); err == nil {
 					_, _ = conn.Write(nMsg)
 				}
+			} else if updateErr, ok := errors.AsType[*update.Error](ctxCause); ok {
+				if nMsg, err := notification.GetNotification(notification.UpdateMessageError, updateErr.SubCode, []byte{}); err == nil {
+					_, _ = conn.Write(nMsg)
+				}
 			}
 			return ctxCause
 		}
 		// Context was not canceled, error in the function
-		if nMsg, err := notification.GetNotification(notification.UpdateMessageError, notification.UpdateMessageErrorUnspecific, []byte{}); err == nil {
+		if errors.Is(err, errConnectionLost) {
+			return err
+		}
+		if _, ok := errors.AsType[notification.Msg](err); ok {
+			// Closed by the peer, a NOTIFICATION message is never answered
+			return err
+		}
+		subCode := notification.UpdateMessageErrorUnspecific
+		if updateErr, ok := errors.AsType[*update.Error](err); ok {
+			subCode = updateErr.SubCode
+		}
+		if nMsg, err := notification.GetNotification(notification.UpdateMessageError, subCode, []byte{}); err == nil {
 			_, _ = conn.Write(nMsg)
 		}
 		return err
 	}
 	logger.Info("BGP Connection closed")
+	// Stop the keepalive handler
+	ctxCancel(nil)
 	return nil
 }
 
@@ -319,7 +410,7 @@ func keepAliveHandler(ctx context.Context, ctxCancel context.CancelCauseFunc, wg
 			_, err := conn.Write(keepAliveBytes)
 			if err != nil {
 				logger.Debug("Error sending keepalive", "error", err)
-				ctxCancel(err)
+				ctxCancel(wrapConnectionLost(err))
 				return
 			}
 		}
@@ -368,8 +459,9 @@ func handleMessages(ctx context.Context, logger *slog.Logger, conn io.Reader, se
 
 		msg, r, err := common.ReadMessage(conn)
 		if err != nil {
-			return err
+			return wrapConnectionLost(err)
 		}
+		session.Stats.CountMessage(msg.Header)
 		switch msg.Header.BgpType {
 		case common.MsgNotification:
 			notificationMsg, err := notification.ParseMsgNotification(r)
@@ -377,10 +469,7 @@ func handleMessages(ctx context.Context, logger *slog.Logger, conn io.Reader, se
 				return fmt.Errorf("failed parsing NOTIFICATION message %w", err)
 			}
 			logger.Debug("BGP notification", "message", notificationMsg)
-			if notificationMsg.ErrorCode != notification.Cease {
-				return notificationMsg
-			}
-			return nil
+			return notificationMsg
 		case common.MsgKeepAlive:
 			logger.Debug("Received keepalive message")
 			select {
@@ -395,7 +484,15 @@ func handleMessages(ctx context.Context, logger *slog.Logger, conn io.Reader, se
 			case keepAliveChan <- struct{}{}:
 			default:
 			}
-			msg.Body, err = update.ParseMsgUpdate(r, session.DefaultAFI, session.AddPathEnabled)
+			// The body is read completely so that parsing errors are caused by the message content only
+			body, err := io.ReadAll(r)
+			if err != nil {
+				return wrapConnectionLost(err)
+			}
+			if mrt.RecordingEnabled() {
+				mrt.RecordUpdate(session, body)
+			}
+			msg.Body, err = update.DecodeMsgUpdate(body, session.DefaultAFI, session.AddPathEnabled)
 			if err != nil {
 				return fmt.Errorf("failed parsing UPDATE message %w", err)
 			}
@@ -408,7 +505,16 @@ func handleMessages(ctx context.Context, logger *slog.Logger, conn io.Reader, se
 		// Discard any unread bytes
 		_, err = io.Copy(io.Discard, r)
 		if err != nil {
-			return err
+			return wrapConnectionLost(err)
 		}
 	}
 }
+
+// wrapConnectionLost marks errors caused by the loss of the underlying connection
+func wrapConnectionLost(err error) error {
+	var netErr net.Error
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &netErr) {
+		return fmt.Errorf("%w: %w", errConnectionLost, err)
+	}
+	return err
+}
@@ -0,0 +1,276 @@
+package bmp
+
+import (
+	"FlapAlerted/bgp/common"
+	"FlapAlerted/bgp/notification"
+	"FlapAlerted/bgp/open"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"slices"
+)
+
+func readMessage(r io.Reader) (header commonHeader, body []byte, err error) {
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return header, nil, err
+	}
+	if header.Version != bmpVersion {
+		return header, nil, fmt.Errorf("BMP version not supported: %d", header.Version)
+	}
+	if header.Length < commonHeaderLength || header.Length > maxMessageLength {
+		return header, nil, fmt.Errorf("invalid BMP message length: %d", header.Length)
+	}
+	body = make([]byte, header.Length-commonHeaderLength)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return header, nil, err
+	}
+	return header, body, nil
+}
+
+func parsePerPeerHeader(r io.Reader) (header perPeerHeader, err error) {
+	err = binary.Read(r, binary.BigEndian, &header)
+	return header, err
+}
+
+func parseInformation(r io.Reader) ([]information, error) {
+	result := make([]information, 0)
+	for {
+		var i information
+		if err := binary.Read(r, binary.BigEndian, &i.Type); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, err
+		}
+		var length uint16
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			return nil, err
+		}
+		i.Value = make([]byte, length)
+		if _, err := io.ReadFull(r, i.Value); err != nil {
+			return nil, err
+		}
+		result = append(result, i)
+	}
+	return result, nil
+}
+
+// parseRouteMonitoring returns the body of the BGP UPDATE message contained in a Route Monitoring message
+func parseRouteMonitoring(b []byte) (common.BgpHeader, []byte, error) {
+	header, body, err := common.SliceMessage(b)
+	if err != nil {
+		return header, nil, err
+	}
+	if header.BgpType != common.MsgUpdate {
+		return header, nil, fmt.Errorf("unexpected message of type '%s', expected update", header.BgpType)
+	}
+	return header, body, nil
+}
+
+type peerUpMsg struct {
+	LocalAddress netip.Addr
+	LocalPort    uint16
+	RemotePort   uint16
+	SentOpen     open.Msg // Sent by the monitored router
+	ReceivedOpen open.Msg // Received from the peer
+}
+
+func parsePeerUp(r io.Reader, header perPeerHeader) (msg peerUpMsg, err error) {
+	var localAddress [16]byte
+	if err := binary.Read(r, binary.BigEndian, &localAddress); err != nil {
+		return msg, err
+	}
+	if header.Flags&peerFlagIPv6 != 0 {
+		msg.LocalAddress = netip.AddrFrom16(localAddress)
+	} else {
+		msg.LocalAddress = netip.AddrFrom4([4]byte(localAddress[12:]))
+	}
+	if err := binary.Read(r, binary.BigEndian, &msg.LocalPort); err != nil {
+		return msg, err
+	}
+	if err := binary.Read(r, binary.BigEndian, &msg.RemotePort); err != nil {
+		return msg, err
+	}
+	msg.SentOpen, err = readOpen(r)
+	if err != nil {
+		return msg, fmt.Errorf("error parsing sent OPEN message: %w", err)
+	}
+	msg.ReceivedOpen, err = readOpen(r)
+	if err != nil {
+		return msg, fmt.Errorf("error parsing received OPEN message: %w", err)
+	}
+	return msg, nil
+}
+
+func readOpen(r io.Reader) (open.Msg, error) {
+	msg, bodyReader, err := common.ReadMessage(r)
+	if err != nil {
+		return open.Msg{}, err
+	}
+	if msg.Header.BgpType != common.MsgOpen {
+		return open.Msg{}, fmt.Errorf("unexpected message of type '%s', expected open", msg.Header.BgpType)
+	}
+	result, err := open.ParseMsgOpen(bodyReader)
+	if err != nil {
+		return open.Msg{}, err
+	}
+	// Discard any unread bytes
+	_, err = io.Copy(io.Discard, bodyReader)
+	return result, err
+}
+
+type peerDownMsg struct {
+	Reason       peerDownReason
+	Notification *notification.Msg // Only present if the session was closed with a notification
+}
+
+func parsePeerDown(r io.Reader) (msg peerDownMsg, err error) {
+	if err := binary.Read(r, binary.BigEndian, &msg.Reason); err != nil {
+		return msg, err
+	}
+	if msg.Reason != peerDownLocalNotification && msg.Reason != peerDownRemoteNotification {
+		return msg, nil
+	}
+	header, bodyReader, err := common.ReadMessage(r)
+	if err != nil {
+		return msg, err
+	}
+	if header.Header.BgpType != common.MsgNotification {
+		return msg, fmt.Errorf("unexpected message of type '%s', expected notification", header.Header.BgpType)
+	}
+	n, err := notification.ParseMsgNotification(bodyReader)
+	if err != nil {
+		return msg, err
+	}
+	msg.Notification = &n
+	return msg, nil
+}
+
+// newMonitoredSession derives the session parameters negotiated between the monitored router and its peer
+func newMonitoredSession(header perPeerHeader, peerUp *peerUpMsg) (*common.LocalSession, error) {
+	session := &common.LocalSession{
+		DefaultAFI:        common.AFI4,
+		RemoteAsn:         header.AS,
+		RemoteAddress:     header.peerAddress(),
+		RemoteRouterID:    header.routerID(),
+		TwoByteAsPath:     header.Flags&peerFlagTwoByteAsPath != 0,
+		MultiProtocolAFIs: []common.AFI{common.AFI4, common.AFI6},
+	}
+	if peerUp == nil {
+		// Route Monitoring messages were received without a preceding Peer Up message
+		return session, nil
+	}
+
+	session.LocalAddress = peerUp.LocalAddress
+	session.OwnRouterID = peerUp.SentOpen.RouterID.ToNetAddr()
+	session.Asn = uint32(peerUp.SentOpen.ASN)
+	session.OwnHoldTime = peerUp.SentOpen.HoldTime.GetApplicableSeconds()
+	session.ApplicableHoldTime = min(session.OwnHoldTime, peerUp.ReceivedOpen.HoldTime.GetApplicableSeconds())
+
+	sent := capabilitiesOf(peerUp.SentOpen)
+	received := capabilitiesOf(peerUp.ReceivedOpen)
+
+	sentMultiProtocol, receivedMultiProtocol := make([]common.AFI, 0), make([]common.AFI, 0)
+	sentAddPath, receivedAddPath := make(map[common.AFI]bool), make(map[common.AFI]bool)
+	for _, c := range sent {
+		switch v := c.(type) {
+		case open.FourByteASNCapability:
+			session.Asn = v.ASN
+		case open.RoleCapability:
+			session.OwnRole = &v.Role
+		case open.MultiProtocolCapability:
+			if v.SAFI == common.UNICAST {
+				sentMultiProtocol = append(sentMultiProtocol, v.AFI)
+			}
+		case open.AddPathCapabilityList:
+			for _, ac := range v {
+				if ac.SAFI == common.UNICAST && ac.TXRX != open.SendOnly {
+					sentAddPath[ac.AFI] = true
+				}
+			}
+		}
+	}
+	for _, c := range received {
+		switch v := c.(type) {
+		case open.MultiProtocolCapability:
+			if v.SAFI == common.UNICAST {
+				receivedMultiProtocol = append(receivedMultiProtocol, v.AFI)
+			}
+		case open.AddPathCapabilityList:
+			for _, ac := range v {
+				if ac.SAFI == common.UNICAST && ac.TXRX != open.ReceiveOnly {
+					receivedAddPath[ac.AFI] = true
+				}
+			}
+		case open.HostnameCapability:
+			session.RemoteHostname = v.String()
+		case open.RoleCapability:
+			session.RemoteRole = &v.Role
+		}
+	}
+	if session.RemoteRole == nil && session.OwnRole != nil {
+		if role, ok := session.OwnRole.Counterpart(); ok {
+			session.RemoteRole = &role
+		}
+	}
+	session.HasExtendedMessages = hasExtendedMessages(sent) && hasExtendedMessages(received)
+	session.HasExtendedNextHopV4 = hasExtendedNextHopV4(sent) && hasExtendedNextHopV4(received)
+
+	// Without multiprotocol capabilities only IPv4 unicast routes are exchanged
+	if len(sentMultiProtocol) != 0 || len(receivedMultiProtocol) != 0 {
+		session.MultiProtocolAFIs = make([]common.AFI, 0, 2)
+		for _, afi := range []common.AFI{common.AFI4, common.AFI6} {
+			if slices.Contains(sentMultiProtocol, afi) && slices.Contains(receivedMultiProtocol, afi) {
+				session.MultiProtocolAFIs = append(session.MultiProtocolAFIs, afi)
+			}
+		}
+	} else {
+		session.MultiProtocolAFIs = []common.AFI{common.AFI4}
+	}
+
+	// Paths are received with identifiers if the router can receive them and the peer can send them
+	addPathIPv4 := sentAddPath[common.AFI4] && receivedAddPath[common.AFI4]
+	addPathIPv6 := sentAddPath[common.AFI6] && receivedAddPath[common.AFI6]
+	if addPathIPv4 != addPathIPv6 &&
+		slices.Contains(session.MultiProtocolAFIs, common.AFI4) && slices.Contains(session.MultiProtocolAFIs, common.AFI6) {
+		return nil, errors.New("addPath negotiated for only one address family is not supported")
+	}
+	session.AddPathEnabled = addPathIPv4 || addPathIPv6
+	return session, nil
+}
+
+func capabilitiesOf(msg open.Msg) []open.CapabilityValue {
+	result := make([]open.CapabilityValue, 0)
+	for _, p := range msg.OptionalParameters {
+		if list, ok := p.ParameterValue.(open.CapabilityList); ok {
+			for _, c := range list.List {
+				result = append(result, c.CapabilityValue)
+			}
+		}
+	}
+	return result
+}
+
+func hasExtendedMessages(capabilities []open.CapabilityValue) bool {
+	for _, c := range capabilities {
+		if _, ok := c.(open.ExtendedMessageCapability); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func hasExtendedNextHopV4(capabilities []open.CapabilityValue) bool {
+	for _, c := range capabilities {
+		if list, ok := c.(open.ExtendedNextHopCapabilityList); ok {
+			for _, ec := range list {
+				if ec.AFI == common.AFI4 && ec.NextHopAFI == common.AFI6 && ec.SAFI == common.UNICAST {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
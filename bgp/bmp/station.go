@@ -0,0 +1,327 @@
+package bmp
+
+import (
+	"FlapAlerted/bgp/common"
+	"FlapAlerted/bgp/session"
+	"FlapAlerted/bgp/table"
+	"FlapAlerted/bgp/update"
+	"FlapAlerted/config"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+)
+
+/*
+- RFCs implemented:
+- "BGP Monitoring Protocol (BMP)" https://datatracker.ietf.org/doc/html/rfc7854
+*/
+
+// errMonitoringStopped is the close reason of the sessions of a router whose BMP connection was closed
+var errMonitoringStopped = errors.New("BMP connection closed")
+
+// Serve accepts connections of BMP speakers until the context is canceled.
+// Every monitored peer of a router is treated like a separate BGP session with its own table.
+// Only pre-policy Adj-RIB-In routes are processed.
+func Serve(ctx context.Context, listener net.Listener, pathChangeChan chan table.PathChange) {
+	defer func() {
+		_ = listener.Close()
+	}()
+	go func() {
+		<-ctx.Done()
+		_ = listener.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				slog.Info("BMP listener stopped", "reason", ctx.Err())
+				return
+			default:
+				slog.Warn("Failed to accept TCP connection", "error", err)
+				continue
+			}
+		}
+		wg.Go(func() {
+			handleConnection(ctx, conn, pathChangeChan)
+		})
+	}
+}
+
+type router struct {
+	address        string
+	addr           netip.Addr // Router address without the port
+	logger         *slog.Logger
+	pathChangeChan chan table.PathChange
+	peers          map[peerKey]*monitoredPeer
+	// Peers for which routes are ignored until the next Peer Up message
+	disabledPeers map[peerKey]struct{}
+}
+
+type monitoredPeer struct {
+	session       *common.LocalSession
+	logger        *slog.Logger
+	ctx           context.Context
+	cancel        context.CancelCauseFunc
+	updateChannel chan table.SessionUpdateMessage
+	table         *table.PrefixTable
+	done          chan struct{}
+}
+
+func handleConnection(ctx context.Context, conn net.Conn, pathChangeChan chan table.PathChange) {
+	defer func() {
+		_ = conn.Close()
+	}()
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.Close()
+	})
+	defer stop()
+
+	r := &router{
+		address:        conn.RemoteAddr().String(),
+		logger:         slog.With("bmpRouter", conn.RemoteAddr()),
+		pathChangeChan: pathChangeChan,
+		peers:          make(map[peerKey]*monitoredPeer),
+		disabledPeers:  make(map[peerKey]struct{}),
+	}
+	if tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		r.addr = tcpAddr.AddrPort().Addr().Unmap()
+	}
+	defer r.removeAllPeers()
+	r.logger.Info("New BMP connection")
+
+	err := r.handleMessages(bufio.NewReaderSize(conn, 4096*10))
+	if ctx.Err() != nil {
+		r.logger.Info("BMP connection closed due to local shutdown")
+		return
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		r.logger.Error("BMP connection encountered an error", "error", err.Error())
+		return
+	}
+	r.logger.Info("BMP connection closed")
+}
+
+func (r *router) handleMessages(conn *bufio.Reader) error {
+	for {
+		header, body, err := readMessage(conn)
+		if err != nil {
+			return err
+		}
+		r.logger.Debug("Received BMP message", "type", header.Type, "length", header.Length)
+		reader := bytes.NewReader(body)
+
+		switch header.Type {
+		case msgInitiation:
+			info, err := parseInformation(reader)
+			if err != nil {
+				return fmt.Errorf("failed parsing Initiation message: %w", err)
+			}
+			for _, i := range info {
+				switch i.Type {
+				case informationSysName:
+					r.address = string(i.Value) + " (" + r.address + ")"
+					r.logger = r.logger.With("sysName", string(i.Value))
+				case informationSysDescr:
+					r.logger.Debug("Router description", "sysDescr", string(i.Value))
+				}
+			}
+			r.logger.Info("BMP session initiated")
+		case msgTermination:
+			info, err := parseInformation(reader)
+			if err != nil {
+				return fmt.Errorf("failed parsing Termination message: %w", err)
+			}
+			for _, i := range info {
+				if code, ok := i.reasonCode(); ok && i.Type == informationTerminationReason {
+					r.logger.Info("BMP session terminated by router", "reason_code", code)
+				}
+			}
+			return nil
+		case msgPeerUp:
+			peerHeader, err := parsePerPeerHeader(reader)
+			if err != nil {
+				return fmt.Errorf("failed parsing Peer Up message: %w", err)
+			}
+			if !peerHeader.isPrePolicyAdjRIBIn() {
+				continue
+			}
+			peerUp, err := parsePeerUp(reader, peerHeader)
+			if err != nil {
+				return fmt.Errorf("failed parsing Peer Up message: %w", err)
+			}
+			key := peerHeader.key()
+			r.removePeer(key, nil)
+			delete(r.disabledPeers, key)
+			r.addPeer(key, peerHeader, &peerUp)
+		case msgPeerDown:
+			peerHeader, err := parsePerPeerHeader(reader)
+			if err != nil {
+				return fmt.Errorf("failed parsing Peer Down message: %w", err)
+			}
+			if !peerHeader.isPrePolicyAdjRIBIn() {
+				continue
+			}
+			peerDown, err := parsePeerDown(reader)
+			if err != nil {
+				return fmt.Errorf("failed parsing Peer Down message: %w", err)
+			}
+			key := peerHeader.key()
+			if p, found := r.peers[key]; found {
+				if peerDown.Notification != nil {
+					p.logger.Info("Monitored session down", "reason", peerDown.Reason, "notification", *peerDown.Notification)
+				} else {
+					p.logger.Info("Monitored session down", "reason", peerDown.Reason)
+				}
+			}
+			var reason error = fmt.Errorf("peer down: %s", peerDown.Reason)
+			if peerDown.Notification != nil {
+				reason = fmt.Errorf("%w: %w", reason, *peerDown.Notification)
+			}
+			r.removePeer(key, reason)
+			delete(r.disabledPeers, key)
+		case msgRouteMonitoring:
+			peerHeader, err := parsePerPeerHeader(reader)
+			if err != nil {
+				return fmt.Errorf("failed parsing Route Monitoring message: %w", err)
+			}
+			if !peerHeader.isPrePolicyAdjRIBIn() {
+				continue
+			}
+			// The UPDATE message is decoded from the remaining bytes without copying them
+			r.handleRouteMonitoring(peerHeader, body[len(body)-reader.Len():])
+		case msgStatisticsReport, msgRouteMirroring:
+			// Ignored
+		default:
+			r.logger.Debug("Ignoring BMP message of unknown type", "type", header.Type)
+		}
+	}
+}
+
+func (r *router) handleRouteMonitoring(peerHeader perPeerHeader, b []byte) {
+	key := peerHeader.key()
+	if _, disabled := r.disabledPeers[key]; disabled {
+		return
+	}
+	p, found := r.peers[key]
+	if !found {
+		p = r.addPeer(key, peerHeader, nil)
+		if p == nil {
+			return
+		}
+	}
+	if p.ctx.Err() != nil {
+		r.disablePeer(key)
+		return
+	}
+
+	header, body, err := parseRouteMonitoring(b)
+	if err != nil {
+		p.cancel(fmt.Errorf("failed parsing Route Monitoring message: %w", err))
+		r.disablePeer(key)
+		return
+	}
+	p.session.Stats.CountMessage(header)
+	msg, err := update.DecodeMsgUpdate(body, p.session.DefaultAFI, p.session.AddPathEnabled)
+	if err != nil {
+		p.cancel(fmt.Errorf("failed parsing UPDATE message: %w", err))
+		r.disablePeer(key)
+		return
+	}
+	select {
+	case p.updateChannel <- table.SessionUpdateMessage{Msg: msg, Session: p.session}:
+	case <-p.ctx.Done():
+		r.disablePeer(key)
+	}
+}
+
+// addPeer starts processing the routes of a monitored peer. It returns nil if the peer cannot be monitored.
+func (r *router) addPeer(key peerKey, peerHeader perPeerHeader, peerUp *peerUpMsg) *monitoredPeer {
+	logger := r.logger.With("peer", key.address, "routerID", peerHeader.routerID(), "asn", peerHeader.AS)
+	if key.distinguisher != 0 {
+		logger = logger.With("distinguisher", key.distinguisher)
+	}
+
+	localSession, err := newMonitoredSession(peerHeader, peerUp)
+	if err != nil {
+		logger.Warn("Routes of monitored peer are ignored", "error", err)
+		r.disabledPeers[key] = struct{}{}
+		return nil
+	}
+	localSession.BMPRouter = r.addr
+	localSession.ImportLimit = config.GlobalConf.ImportLimit
+	localSession.SetImportLimitWarning(config.GlobalConf.ImportLimitWarning)
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	p := &monitoredPeer{
+		session:       localSession,
+		logger:        logger,
+		ctx:           ctx,
+		cancel:        cancel,
+		updateChannel: make(chan table.SessionUpdateMessage, 10000),
+		done:          make(chan struct{}),
+	}
+
+	t := table.NewPrefixTable(r.pathChangeChan, cancel, localSession)
+	p.table = t
+	t.SetImportWarning(localSession.ImportLimitWarning, func() {
+		logger.Warn("Import limit warning threshold exceeded", "count", t.ImportCount(), "limit", localSession.ImportLimit)
+		session.WarnImportLimit(localSession)
+	})
+	// The router sends End-of-RIB markers after the initial dump of the Adj-RIB-In
+	t.ExpectEndOfRIB(localSession.MultiProtocolAFIs)
+	go func() {
+		defer close(p.done)
+		table.ProcessUpdates(cancel, p.updateChannel, t)
+	}()
+	session.AddMonitoredSession(r.address, key.address, localSession, t)
+
+	r.peers[key] = p
+	if peerUp != nil {
+		logger.Info("Monitored session up", "hostname", localSession.RemoteHostname, "addPath", localSession.AddPathEnabled)
+	} else {
+		logger.Info("Monitoring peer without Peer Up message")
+	}
+	return p
+}
+
+// disablePeer stops processing the routes of a peer that encountered an error until its next Peer Up message
+func (r *router) disablePeer(key peerKey) {
+	var reason error
+	if p, found := r.peers[key]; found {
+		reason = context.Cause(p.ctx)
+		p.logger.Warn("Routes of monitored peer are ignored until its session is reestablished", "reason", reason)
+	}
+	r.removePeer(key, reason)
+	r.disabledPeers[key] = struct{}{}
+}
+
+func (r *router) removePeer(key peerKey, reason error) {
+	p, found := r.peers[key]
+	if !found {
+		return
+	}
+	delete(r.peers, key)
+	session.RemoveSession(p.session, reason)
+	close(p.updateChannel)
+	<-p.done
+	p.table.Discard()
+	p.cancel(nil)
+}
+
+func (r *router) removeAllPeers() {
+	for key := range r.peers {
+		r.removePeer(key, errMonitoringStopped)
+	}
+}
@@ -0,0 +1,164 @@
+package bmp
+
+import (
+	"encoding/binary"
+	"net/netip"
+)
+
+const bmpVersion = 3
+
+// Upper bound for the length of a single BMP message, which is at most a few BGP messages long
+const maxMessageLength = 1 << 20
+
+type msgType uint8
+
+const (
+	msgRouteMonitoring  msgType = 0
+	msgStatisticsReport msgType = 1
+	msgPeerDown         msgType = 2
+	msgPeerUp           msgType = 3
+	msgInitiation       msgType = 4
+	msgTermination      msgType = 5
+	msgRouteMirroring   msgType = 6
+)
+
+func (m msgType) String() string {
+	switch m {
+	case msgRouteMonitoring:
+		return "Route Monitoring"
+	case msgStatisticsReport:
+		return "Statistics Report"
+	case msgPeerDown:
+		return "Peer Down"
+	case msgPeerUp:
+		return "Peer Up"
+	case msgInitiation:
+		return "Initiation"
+	case msgTermination:
+		return "Termination"
+	case msgRouteMirroring:
+		return "Route Mirroring"
+	}
+	return "Unknown"
+}
+
+type commonHeader struct {
+	Version uint8
+	Length  uint32
+	Type    msgType
+}
+
+// Length of the common header on the wire
+const commonHeaderLength = 6
+
+type peerType uint8
+
+const (
+	peerTypeGlobalInstance peerType = 0
+	peerTypeRDInstance     peerType = 1
+	peerTypeLocalInstance  peerType = 2
+	peerTypeLocRIBInstance peerType = 3
+)
+
+type peerFlags uint8
+
+const (
+	peerFlagIPv6          peerFlags = 0x80
+	peerFlagPostPolicy    peerFlags = 0x40
+	peerFlagTwoByteAsPath peerFlags = 0x20
+	peerFlagAdjRIBOut     peerFlags = 0x10 // RFC 8671
+)
+
+type perPeerHeader struct {
+	PeerType      peerType
+	Flags         peerFlags
+	Distinguisher uint64
+	Address       [16]byte
+	AS            uint32
+	BGPID         [4]byte
+	TimestampSec  uint32
+	TimestampUsec uint32
+}
+
+// isPrePolicyAdjRIBIn returns true if the header describes routes received by the router before any policy was applied
+func (h perPeerHeader) isPrePolicyAdjRIBIn() bool {
+	if h.PeerType > peerTypeLocalInstance {
+		return false
+	}
+	return h.Flags&(peerFlagPostPolicy|peerFlagAdjRIBOut) == 0
+}
+
+func (h perPeerHeader) peerAddress() netip.Addr {
+	if h.Flags&peerFlagIPv6 != 0 {
+		return netip.AddrFrom16(h.Address)
+	}
+	return netip.AddrFrom4([4]byte(h.Address[12:]))
+}
+
+func (h perPeerHeader) routerID() netip.Addr {
+	return netip.AddrFrom4(h.BGPID)
+}
+
+func (h perPeerHeader) key() peerKey {
+	return peerKey{
+		peerType:      h.PeerType,
+		distinguisher: h.Distinguisher,
+		address:       h.peerAddress(),
+	}
+}
+
+// Identifies a monitored peer within the BMP stream of a router
+type peerKey struct {
+	peerType      peerType
+	distinguisher uint64
+	address       netip.Addr
+}
+
+type peerDownReason uint8
+
+const (
+	peerDownLocalNotification   peerDownReason = 1
+	peerDownLocalNoNotification peerDownReason = 2
+	peerDownRemoteNotification  peerDownReason = 3
+	peerDownRemoteNoData        peerDownReason = 4
+	peerDownDeConfigured        peerDownReason = 5
+)
+
+func (r peerDownReason) String() string {
+	switch r {
+	case peerDownLocalNotification:
+		return "local system closed the session with a notification"
+	case peerDownLocalNoNotification:
+		return "local system closed the session without a notification"
+	case peerDownRemoteNotification:
+		return "remote system closed the session with a notification"
+	case peerDownRemoteNoData:
+		return "remote system closed the session without a notification"
+	case peerDownDeConfigured:
+		return "peer de-configured"
+	}
+	return "unknown reason"
+}
+
+type informationType uint16
+
+const (
+	informationString   informationType = 0
+	informationSysDescr informationType = 1
+	informationSysName  informationType = 2
+
+	// Only in Termination messages
+	informationTerminationReason informationType = 1
+)
+
+type information struct {
+	Type  informationType
+	Value []byte
+}
+
+func (i information) reasonCode() (uint16, bool) {
+	if len(i.Value) != 2 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint16(i.Value), true
+}
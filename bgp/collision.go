@@ -0,0 +1,113 @@
+package bgp
+
+import (
+	"FlapAlerted/bgp/notification"
+	"context"
+	"net/netip"
+	"slices"
+	"sync"
+)
+
+// Tracking of connections per peer for connection collision detection as per RFC 4271 section 6.8
+// and for detecting restarted peers as per RFC 4724 section 4.2.
+
+type peerKey struct {
+	remoteAddr     netip.Addr
+	remoteRouterID netip.Addr
+}
+
+type trackedConnection struct {
+	outbound    bool
+	established bool
+	cancel      context.CancelCauseFunc
+	done        chan struct{}
+}
+
+func newTrackedConnection(outbound bool, cancel context.CancelCauseFunc) *trackedConnection {
+	return &trackedConnection{
+		outbound: outbound,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+}
+
+var (
+	connectionTracker     = make(map[peerKey][]*trackedConnection)
+	connectionTrackerLock sync.Mutex
+)
+
+// resolveCollision registers a connection that has received the OPEN message of its peer.
+// Collisions are only detected if detectCollisions is set, as only connections to configured neighbors can be
+// initiated by both sides. It returns notification.ErrConnectionCollision if this connection must be closed.
+// If the peer supports graceful restart, established sessions of the peer are closed and awaited.
+func resolveCollision(ctx context.Context, key peerKey, c *trackedConnection, ownRouterID netip.Addr, detectCollisions, gracefulRestart bool) error {
+	restarted, err := func() ([]*trackedConnection, error) {
+		connectionTrackerLock.Lock()
+		defer connectionTrackerLock.Unlock()
+
+		// The connection initiated by the speaker with the higher BGP identifier is kept
+		keepOutbound := ownRouterID.Compare(key.remoteRouterID) > 0
+
+		if detectCollisions && !gracefulRestart {
+			for _, other := range connectionTracker[key] {
+				if other.established {
+					return nil, notification.ErrConnectionCollision
+				}
+			}
+		}
+
+		restarted := make([]*trackedConnection, 0)
+		remaining := make([]*trackedConnection, 0, len(connectionTracker[key])+1)
+		for _, other := range connectionTracker[key] {
+			if other.established {
+				if gracefulRestart {
+					other.cancel(errPeerRestarted)
+					restarted = append(restarted, other)
+					continue
+				}
+			} else if detectCollisions && other.outbound != c.outbound {
+				if c.outbound != keepOutbound {
+					return nil, notification.ErrConnectionCollision
+				}
+				other.cancel(notification.ErrConnectionCollision)
+				continue
+			}
+			remaining = append(remaining, other)
+		}
+		connectionTracker[key] = append(remaining, c)
+		return restarted, nil
+	}()
+	if err != nil {
+		return err
+	}
+
+	// Wait for the previous sessions to be closed so that their tables are retained
+	for _, other := range restarted {
+		select {
+		case <-other.done:
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		}
+	}
+	return nil
+}
+
+func markEstablished(key peerKey, c *trackedConnection) {
+	connectionTrackerLock.Lock()
+	defer connectionTrackerLock.Unlock()
+	if slices.Contains(connectionTracker[key], c) {
+		c.established = true
+	}
+}
+
+func untrackConnection(key peerKey, c *trackedConnection) {
+	connectionTrackerLock.Lock()
+	defer connectionTrackerLock.Unlock()
+	connectionTracker[key] = slices.DeleteFunc(connectionTracker[key], func(other *trackedConnection) bool {
+		return other == c
+	})
+	if len(connectionTracker[key]) == 0 {
+		delete(connectionTracker, key)
+	}
+	close(c.done)
+}
@@ -0,0 +1,106 @@
+package common
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type Origin uint8
+
+const (
+	OriginIGP        Origin = 0
+	OriginEGP        Origin = 1
+	OriginIncomplete Origin = 2
+)
+
+// PathAttributes holds the attributes of a path other than the AS path.
+// A single value is shared by all paths announced in the same UPDATE message and must not be modified.
+type PathAttributes struct {
+	Origin           Origin
+	NextHop          netip.Addr
+	LinkLocalNextHop netip.Addr `json:",omitzero"`
+	// Optional attributes, the Has fields are set if the attribute was present
+	MultiExitDisc       uint32
+	HasMultiExitDisc    bool
+	LocalPref           uint32
+	HasLocalPref        bool
+	AtomicAggregate     bool
+	Aggregator          *Aggregator      `json:",omitempty"`
+	Communities         []uint32         `json:",omitempty"`
+	ExtendedCommunities []uint64         `json:",omitempty"`
+	LargeCommunities    []LargeCommunity `json:",omitempty"`
+	// ASN of the Only to Customer attribute (RFC 9234)
+	OnlyToCustomer    uint32
+	HasOnlyToCustomer bool
+}
+
+type Aggregator struct {
+	Asn     uint32
+	Address netip.Addr
+}
+
+type LargeCommunity struct {
+	GlobalAdministrator uint32
+	LocalData1          uint32
+	LocalData2          uint32
+}
+
+// ParseCommunity parses a community (RFC 1997) given as "asn:value"
+func ParseCommunity(s string) (uint32, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid community %q: expected asn:value", s)
+	}
+	var values [2]uint64
+	for i, part := range parts {
+		v, err := strconv.ParseUint(part, 10, 16)
+		if err != nil {
+			return 0, fmt.Errorf("invalid community %q: %w", s, err)
+		}
+		values[i] = v
+	}
+	return uint32(values[0]<<16 | values[1]), nil
+}
+
+// ParseLargeCommunity parses a large community (RFC 8092) given as "asn:value:value"
+func ParseLargeCommunity(s string) (LargeCommunity, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return LargeCommunity{}, fmt.Errorf("invalid large community %q: expected asn:value:value", s)
+	}
+	var values [3]uint32
+	for i, part := range parts {
+		v, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return LargeCommunity{}, fmt.Errorf("invalid large community %q: %w", s, err)
+		}
+		values[i] = uint32(v)
+	}
+	return LargeCommunity{GlobalAdministrator: values[0], LocalData1: values[1], LocalData2: values[2]}, nil
+}
+
+// Equal reports whether both attribute sets are identical. Nil is only equal to nil.
+func (a *PathAttributes) Equal(b *PathAttributes) bool {
+	if a == b {
+		return true
+	}
+	if a == nil || b == nil {
+		return false
+	}
+	if a.Origin != b.Origin || a.NextHop != b.NextHop || a.LinkLocalNextHop != b.LinkLocalNextHop ||
+		a.MultiExitDisc != b.MultiExitDisc || a.HasMultiExitDisc != b.HasMultiExitDisc ||
+		a.LocalPref != b.LocalPref || a.HasLocalPref != b.HasLocalPref ||
+		a.AtomicAggregate != b.AtomicAggregate ||
+		a.OnlyToCustomer != b.OnlyToCustomer || a.HasOnlyToCustomer != b.HasOnlyToCustomer {
+		return false
+	}
+	if (a.Aggregator == nil) != (b.Aggregator == nil) || (a.Aggregator != nil && *a.Aggregator != *b.Aggregator) {
+		return false
+	}
+	return slices.Equal(a.Communities, b.Communities) &&
+		slices.Equal(a.ExtendedCommunities, b.ExtendedCommunities) &&
+		slices.Equal(a.LargeCommunities, b.LargeCommunities)
+}
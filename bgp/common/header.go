@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"strconv"
 )
@@ -93,3 +94,22 @@ func ReadMessage(r io.Reader) (msg BgpMessage, bodyReader io.Reader, err error)
 	bodyReader = io.LimitReader(r, int64(msg.Header.Length)-19)
 	return msg, bodyReader, nil
 }
+
+// SliceMessage parses the header of the message at the start of b and returns its body without copying it
+func SliceMessage(b []byte) (header BgpHeader, body []byte, err error) {
+	if len(b) < 19 {
+		return header, nil, io.ErrUnexpectedEOF
+	}
+	header = BgpHeader{
+		Marker:  [16]byte(b[:16]),
+		Length:  binary.BigEndian.Uint16(b[16:18]),
+		BgpType: msgType(b[18]),
+	}
+	if !bytes.Equal(header.Marker[:], bgpMarker) {
+		return header, nil, errors.New("bgp marker invalid")
+	}
+	if header.Length < 19 || int(header.Length) > len(b) {
+		return header, nil, fmt.Errorf("invalid message length %d: %w", header.Length, io.ErrUnexpectedEOF)
+	}
+	return header, b[19:header.Length], nil
+}
@@ -0,0 +1,127 @@
+package common
+
+import (
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+	"weak"
+)
+
+// AS paths are interned so that a path shared by many prefixes, path IDs and sessions is stored once.
+// The pool only holds weak references, an interned path is removed once the garbage collector
+// finds it to be unreferenced. This avoids having to release paths whenever a table is discarded.
+
+const asPathPoolShards = 64
+
+type asPathPoolShard struct {
+	lock sync.Mutex
+	// Interned paths by hash
+	paths map[uint64][]internedAsPath
+}
+
+type internedAsPath struct {
+	// First element of the backing array
+	first  weak.Pointer[uint32]
+	length int
+}
+
+var asPathPool [asPathPoolShards]asPathPoolShard
+
+var asPathPoolStats struct {
+	paths   atomic.Int64
+	bytes   atomic.Int64
+	lookups atomic.Uint64
+	hits    atomic.Uint64
+}
+
+// AsPathPoolStats describes the AS paths currently interned
+type AsPathPoolStats struct {
+	// Number of distinct interned paths
+	Paths int64
+	// Memory used by the interned paths
+	Bytes int64
+	// Interned paths requested, and how many of them had already been interned
+	Lookups uint64
+	Hits    uint64
+}
+
+func GetAsPathPoolStats() AsPathPoolStats {
+	return AsPathPoolStats{
+		Paths:   asPathPoolStats.paths.Load(),
+		Bytes:   asPathPoolStats.bytes.Load(),
+		Lookups: asPathPoolStats.lookups.Load(),
+		Hits:    asPathPoolStats.hits.Load(),
+	}
+}
+
+// InternAsPath returns a shared path equal to p. Equal paths share the same backing array while they are referenced.
+// The returned path must not be modified. Appending to it always allocates a new array.
+func InternAsPath(p AsPath) AsPath {
+	if len(p) == 0 {
+		return p
+	}
+	asPathPoolStats.lookups.Add(1)
+	hash := hashAsPath(p)
+	shard := &asPathPool[hash%asPathPoolShards]
+
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	for _, interned := range shard.paths[hash] {
+		if interned.length != len(p) {
+			continue
+		}
+		first := interned.first.Value()
+		if first == nil {
+			continue
+		}
+		if existing := AsPath(unsafe.Slice(first, interned.length)); slices.Equal(existing, p) {
+			asPathPoolStats.hits.Add(1)
+			return existing
+		}
+	}
+
+	// Allocations of less than 16 bytes are combined by the runtime and would be freed late
+	path := make(AsPath, len(p), max(len(p), 4))
+	copy(path, p)
+	path = path[:len(p):len(p)]
+	if shard.paths == nil {
+		shard.paths = make(map[uint64][]internedAsPath)
+	}
+	shard.paths[hash] = append(shard.paths[hash], internedAsPath{first: weak.Make(&path[0]), length: len(path)})
+
+	size := int64(max(len(p), 4)) * 4
+	asPathPoolStats.paths.Add(1)
+	asPathPoolStats.bytes.Add(size)
+	runtime.AddCleanup(&path[0], func(size int64) {
+		asPathPoolStats.paths.Add(-1)
+		asPathPoolStats.bytes.Add(-size)
+		shard.removeCollected(hash)
+	}, size)
+	return path
+}
+
+// removeCollected removes the paths with the given hash that are no longer referenced
+func (s *asPathPoolShard) removeCollected(hash uint64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	remaining := slices.DeleteFunc(s.paths[hash], func(interned internedAsPath) bool {
+		return interned.first.Value() == nil
+	})
+	if len(remaining) == 0 {
+		delete(s.paths, hash)
+		return
+	}
+	s.paths[hash] = remaining
+}
+
+// hashAsPath returns the FNV-1a hash of the path
+func hashAsPath(p AsPath) uint64 {
+	hash := uint64(14695981039346656037)
+	for _, asn := range p {
+		hash ^= uint64(asn)
+		hash *= 1099511628211
+	}
+	return hash
+}
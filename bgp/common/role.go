@@ -0,0 +1,76 @@
+package common
+
+import "fmt"
+
+// Role is the relationship of a BGP speaker to its peer (RFC 9234)
+type Role uint8
+
+const (
+	RoleProvider          Role = 0
+	RoleRouteServer       Role = 1
+	RoleRouteServerClient Role = 2
+	RoleCustomer          Role = 3
+	RolePeer              Role = 4
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleProvider:
+		return "provider"
+	case RoleRouteServer:
+		return "rs"
+	case RoleRouteServerClient:
+		return "rs-client"
+	case RoleCustomer:
+		return "customer"
+	case RolePeer:
+		return "peer"
+	}
+	return fmt.Sprintf("unknown (%d)", uint8(r))
+}
+
+func (r Role) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+func ParseRole(s string) (Role, error) {
+	for r := RoleProvider; r <= RolePeer; r++ {
+		if r.String() == s {
+			return r, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown role %q", s)
+}
+
+// Counterpart returns the role the peer must have when the local speaker has this role
+func (r Role) Counterpart() (Role, bool) {
+	switch r {
+	case RoleProvider:
+		return RoleCustomer, true
+	case RoleCustomer:
+		return RoleProvider, true
+	case RoleRouteServer:
+		return RoleRouteServerClient, true
+	case RoleRouteServerClient:
+		return RoleRouteServer, true
+	case RolePeer:
+		return RolePeer, true
+	}
+	return 0, false
+}
+
+// IsPossibleRouteLeak reports whether a path received on the session carries an Only to Customer (OTC)
+// attribute showing that it has been propagated against the customer-to-provider direction (RFC 9234 section 5).
+// Always false if the role of the remote speaker is unknown.
+func (s *LocalSession) IsPossibleRouteLeak(a *PathAttributes) bool {
+	if s.RemoteRole == nil || a == nil || !a.HasOnlyToCustomer {
+		return false
+	}
+	switch *s.RemoteRole {
+	case RoleCustomer, RoleRouteServerClient:
+		return true
+	case RolePeer:
+		return a.OnlyToCustomer != s.RemoteAsn
+	}
+	return false
+}
@@ -1,17 +1,80 @@
 package common
 
 import (
+	"fmt"
 	"net/netip"
+	"sync/atomic"
 )
 
 type LocalSession struct {
 	DefaultAFI           AFI
 	AddPathEnabled       bool
 	Asn                  uint32
+	ExpectedRemoteAsn    uint32 // Zero allows any remote ASN
+	RemoteAsn            uint32
+	RemoteAddress        netip.Addr
+	LocalAddress         netip.Addr
 	OwnRouterID          netip.Addr
+	OwnHoldTime          int
+	OwnHostname          string
+	ImportLimit          uint32 // Maximum number of routes, zero if unlimited
+	ImportLimitWarning   uint32 // Number of routes above which modules are warned, zero if disabled
+	Description          string
 	RemoteRouterID       netip.Addr
 	RemoteHostname       string
 	HasExtendedNextHopV4 bool
 	HasExtendedMessages  bool
 	ApplicableHoldTime   int
+	MultiProtocolAFIs    []AFI
+	TwoByteAsPath        bool       // AS_PATH uses two-octet ASNs, only for sessions monitored via BMP
+	BMPRouter            netip.Addr // Router monitoring the session via BMP, invalid for sessions established with FlapAlerted
+	Announce             bool       // Active flaps are announced to the peer
+
+	// Graceful restart capability of the peer (RFC 4724)
+	HasGracefulRestart  bool
+	RestartTime         int
+	GracefulRestartAFIs []AFI // Address families for which routes are retained while the peer restarts
+	ForwardingStateAFIs []AFI // Address families for which the peer has preserved its forwarding state
+
+	// BGP Role capability (RFC 9234), nil if not configured or not advertised
+	OwnRole    *Role
+	RemoteRole *Role
+
+	UpdateErrors UpdateErrorCounters
+	Stats        SessionStats
+}
+
+// SessionID identifies a BGP session. It remains the same when the session is re-established.
+type SessionID struct {
+	BMPRouter netip.Addr `json:",omitzero"`
+	Remote    netip.Addr
+	RouterID  netip.Addr
+}
+
+func (id SessionID) String() string {
+	s := fmt.Sprintf("%s (%s)", id.Remote, id.RouterID)
+	if id.BMPRouter.IsValid() {
+		s += " via " + id.BMPRouter.String()
+	}
+	return s
+}
+
+func (s *LocalSession) ID() SessionID {
+	return SessionID{
+		BMPRouter: s.BMPRouter,
+		Remote:    s.RemoteAddress,
+		RouterID:  s.RemoteRouterID,
+	}
+}
+
+// UpdateErrorCounters count the errors in received UPDATE messages by the action taken to handle them (RFC 7606)
+type UpdateErrorCounters struct {
+	AttributeDiscard atomic.Uint64
+	TreatAsWithdraw  atomic.Uint64
+	AFIDisable       atomic.Uint64
+}
+
+// SetImportLimitWarning sets the warning threshold to the given percentage of the import limit. Zero disables the warning.
+func (s *LocalSession) SetImportLimitWarning(percentage int) {
+	s.ImportLimitWarning = uint32(uint64(s.ImportLimit) * uint64(percentage) / 100)
 }
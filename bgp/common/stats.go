@@ -0,0 +1,46 @@
+package common
+
+import (
+	"sync/atomic"
+)
+
+// SessionStats count the messages and routes received on a session
+type SessionStats struct {
+	OpenMessages         atomic.Uint64
+	UpdateMessages       atomic.Uint64
+	NotificationMessages atomic.Uint64
+	KeepAliveMessages    atomic.Uint64
+	ReceivedBytes        atomic.Uint64
+	AnnouncedNLRI        atomic.Uint64
+	WithdrawnNLRI        atomic.Uint64
+	lastError            atomic.Pointer[string]
+}
+
+// CountMessage counts a received message, including its header
+func (s *SessionStats) CountMessage(header BgpHeader) {
+	switch header.BgpType {
+	case MsgOpen:
+		s.OpenMessages.Add(1)
+	case MsgUpdate:
+		s.UpdateMessages.Add(1)
+	case MsgNotification:
+		s.NotificationMessages.Add(1)
+	case MsgKeepAlive:
+		s.KeepAliveMessages.Add(1)
+	}
+	s.ReceivedBytes.Add(uint64(header.Length))
+}
+
+// SetLastError records the most recent error encountered by the session
+func (s *SessionStats) SetLastError(err error) {
+	msg := err.Error()
+	s.lastError.Store(&msg)
+}
+
+// LastError returns the most recent error encountered by the session, empty if there was none
+func (s *SessionStats) LastError() string {
+	if msg := s.lastError.Load(); msg != nil {
+		return *msg
+	}
+	return ""
+}
@@ -0,0 +1,82 @@
+package bgp
+
+import (
+	"FlapAlerted/bgp/common"
+	"FlapAlerted/bgp/table"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Receiving speaker procedures for graceful restart as per RFC 4724 section 4.2
+
+var errConnectionLost = errors.New("connection lost")
+var errPeerRestarted = fmt.Errorf("%w: peer restarted", errConnectionLost)
+
+type retainedTable struct {
+	table *table.PrefixTable
+	timer *time.Timer
+}
+
+var (
+	retainedTables     = make(map[peerKey]*retainedTable)
+	retainedTablesLock sync.Mutex
+)
+
+// canRetainTable returns true if the table of a lost session is to be retained while the peer restarts
+func canRetainTable(session *common.LocalSession, closeReason error) bool {
+	return errors.Is(closeReason, errConnectionLost) &&
+		session.HasGracefulRestart && session.RestartTime > 0 && len(session.GracefulRestartAFIs) > 0
+}
+
+// retainTable keeps the paths of a lost session as stale for the restart time advertised by the peer
+func retainTable(key peerKey, t *table.PrefixTable, session *common.LocalSession, logger *slog.Logger) {
+	t.MarkStale(session.GracefulRestartAFIs)
+	restartTime := time.Duration(session.RestartTime) * time.Second
+	logger.Info("Retaining paths while the peer restarts", "restart_time", restartTime)
+
+	retainedTablesLock.Lock()
+	defer retainedTablesLock.Unlock()
+	r := &retainedTable{table: t}
+	r.timer = time.AfterFunc(restartTime, func() {
+		retainedTablesLock.Lock()
+		defer retainedTablesLock.Unlock()
+		if retainedTables[key] == r {
+			delete(retainedTables, key)
+			logger.Info("Peer did not restart in time, removed retained paths")
+			// Sent while locked so that it cannot happen after clearRetainedTables
+			r.table.Discard()
+		}
+	})
+	if previous, found := retainedTables[key]; found {
+		previous.timer.Stop()
+		// Sent while locked like on expiry, the paths of the previous table are replaced without being withdrawn
+		previous.table.Discard()
+	}
+	retainedTables[key] = r
+}
+
+// clearRetainedTables stops waiting for restarting peers. Called when the program stops.
+func clearRetainedTables() {
+	retainedTablesLock.Lock()
+	defer retainedTablesLock.Unlock()
+	for _, r := range retainedTables {
+		r.timer.Stop()
+	}
+	clear(retainedTables)
+}
+
+// takeRetainedTable returns the retained table of a restarted peer, if present
+func takeRetainedTable(key peerKey) *table.PrefixTable {
+	retainedTablesLock.Lock()
+	defer retainedTablesLock.Unlock()
+	r, found := retainedTables[key]
+	if !found {
+		return nil
+	}
+	r.timer.Stop()
+	delete(retainedTables, key)
+	return r.table
+}
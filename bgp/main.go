@@ -1,7 +1,9 @@
 package bgp
 
 import (
+	"FlapAlerted/bgp/bmp"
 	"FlapAlerted/bgp/common"
+	"FlapAlerted/bgp/mrt"
 	"FlapAlerted/bgp/notification"
 	"FlapAlerted/bgp/session"
 	"FlapAlerted/bgp/table"
@@ -11,7 +13,9 @@ import (
 	"fmt"
 	"log/slog"
 	"net"
+	"net/netip"
 	"sync"
+	"time"
 )
 
 /*
@@ -23,16 +27,44 @@ import (
 - "Capabilities Advertisement with BGP-4" https://datatracker.ietf.org/doc/html/rfc3392
 - "Extended Message Support for BGP" https://datatracker.ietf.org/doc/html/rfc8654
 - "Hostname Capability for BGP" https://datatracker.ietf.org/doc/html/draft-walton-bgp-hostname-capability-02
+- "Graceful Restart Mechanism for BGP" https://datatracker.ietf.org/doc/html/rfc4724
+- "Route Leak Prevention and Detection Using Roles in UPDATE and OPEN Messages" https://datatracker.ietf.org/doc/html/rfc9234
+- "BGP Communities Attribute" https://datatracker.ietf.org/doc/html/rfc1997
+- "BGP Large Communities Attribute" https://datatracker.ietf.org/doc/html/rfc8092
 */
 
-func StartBGP(ctx context.Context, parentWg *sync.WaitGroup, bgpListenAddress string) (<-chan table.PathChange, error) {
+// StartBGP accepts and establishes BGP sessions. If announce is not nil, the prefixes it returns are announced
+// to the neighbors that have announcing enabled.
+func StartBGP(ctx context.Context, parentWg *sync.WaitGroup, bgpListenAddress string, announce func() []netip.Prefix) (<-chan table.PathChange, error) {
 	pathChangeChan := make(chan table.PathChange, 1000)
-	listener, err := net.Listen("tcp", bgpListenAddress)
+	lc := listenConfig()
+	listener, err := lc.Listen(ctx, "tcp", bgpListenAddress)
 	if err != nil {
 		return nil, fmt.Errorf("failed to start listener: %w", err)
 	}
+	var bmpListener net.Listener
+	if config.GlobalConf.MrtRecordDirectory != "" {
+		if err := mrt.StartRecording(ctx, parentWg, config.GlobalConf.MrtRecordDirectory, config.GlobalConf.MrtRecordRotation); err != nil {
+			_ = listener.Close()
+			return nil, err
+		}
+	}
+	if config.GlobalConf.BmpListenAddress != "" {
+		bmpListener, err = net.Listen("tcp", config.GlobalConf.BmpListenAddress)
+		if err != nil {
+			_ = listener.Close()
+			return nil, fmt.Errorf("failed to start BMP listener: %w", err)
+		}
+	}
+	if announce != nil {
+		announced = newAnnouncedPrefixes()
+		parentWg.Go(func() {
+			announced.run(ctx, announce)
+		})
+	}
 	parentWg.Go(func() {
 		defer close(pathChangeChan)
+		defer clearRetainedTables()
 		defer func() {
 			_ = listener.Close()
 		}()
@@ -44,6 +76,22 @@ func StartBGP(ctx context.Context, parentWg *sync.WaitGroup, bgpListenAddress st
 
 		var wg sync.WaitGroup
 		defer wg.Wait()
+
+		for _, neighbor := range config.GlobalConf.Neighbors {
+			if !neighbor.Active {
+				continue
+			}
+			wg.Go(func() {
+				connectNeighbor(ctx, neighbor, pathChangeChan)
+			})
+		}
+
+		if bmpListener != nil {
+			wg.Go(func() {
+				bmp.Serve(ctx, bmpListener, pathChangeChan)
+			})
+		}
+
 		for {
 			conn, err := listener.Accept()
 			if err != nil {
@@ -57,19 +105,20 @@ func StartBGP(ctx context.Context, parentWg *sync.WaitGroup, bgpListenAddress st
 				}
 			}
 			wg.Go(func() {
-				handleConnection(ctx, conn, pathChangeChan)
+				handleConnection(ctx, conn, pathChangeChan, false)
 			})
 		}
 	})
 	return pathChangeChan, nil
 }
 
-func handleConnection(parent context.Context, conn net.Conn, pathChangeChan chan table.PathChange) {
+// handleConnection runs a BGP session on the given connection until it is closed.
+// It returns true if the session reached the established state.
+func handleConnection(parent context.Context, conn net.Conn, pathChangeChan chan table.PathChange, outbound bool) (established bool) {
 	defer func() {
 		_ = conn.Close()
 	}()
 	logger := slog.With("remote", conn.RemoteAddr())
-	logger.Info("New connection")
 
 	ctx, cancel := context.WithCancelCause(context.WithoutCancel(parent))
 	defer cancel(nil)
@@ -78,46 +127,197 @@ func handleConnection(parent context.Context, conn net.Conn, pathChangeChan chan
 	})
 	defer stop()
 
+	remoteAddr := remoteAddrOf(conn)
+	if session.IsBlocked(remoteAddr) {
+		logger.Info("Rejected connection from blocked remote")
+		return
+	}
+	neighbor, isNeighbor := config.GlobalConf.FindNeighbor(remoteAddr)
+
+	localSession := &common.LocalSession{
+		DefaultAFI:        common.AFI4,
+		RemoteAddress:     remoteAddr,
+		LocalAddress:      localAddrOf(conn),
+		AddPathEnabled:    config.GlobalConf.UseAddPath,
+		Asn:               config.GlobalConf.Asn,
+		ExpectedRemoteAsn: config.GlobalConf.Asn,
+		OwnRouterID:       config.GlobalConf.RouterID,
+		OwnHoldTime:       defaultHoldTime,
+		OwnHostname:       defaultHostname,
+		ImportLimit:       config.GlobalConf.ImportLimit,
+		Announce:          config.GlobalConf.AnnounceEnabled(),
+	}
+	if isNeighbor {
+		applyNeighborSettings(localSession, neighbor)
+		if neighbor.Description != "" {
+			logger = logger.With("description", neighbor.Description)
+		}
+	}
+	localSession.SetImportLimitWarning(importLimitWarningOf(neighbor, isNeighbor))
+	logger.Info("New connection", "outbound", outbound)
+
+	if !outbound {
+		// Outbound connections are configured before connecting
+		if err := applyMinTTL(conn, minTTLOf(neighbor, isNeighbor)); err != nil {
+			logger.Error("Failed to enable TTL security", "error", err)
+			return
+		}
+	}
+
+	var tracked *trackedConnection
+	var key peerKey
+	defer func() {
+		if tracked != nil {
+			untrackConnection(key, tracked)
+		}
+	}()
+
+	var t *table.PrefixTable
+	var closeReason error
+	defer func() {
+		// Runs after the table is no longer in use
+		if t == nil {
+			return
+		}
+		if canRetainTable(localSession, closeReason) {
+			retainTable(key, t, localSession, logger)
+		} else {
+			t.Discard()
+		}
+	}()
+
 	var wg sync.WaitGroup
 	defer wg.Wait()
 
 	updateChannel := make(chan table.SessionUpdateMessage, 10000)
 	defer close(updateChannel) // Must be after wg.Wait() so it runs first
 
-	localSession := &common.LocalSession{
-		DefaultAFI:     common.AFI4,
-		AddPathEnabled: config.GlobalConf.UseAddPath,
-		Asn:            config.GlobalConf.Asn,
-		OwnRouterID:    config.GlobalConf.RouterID,
+	openConfirm := func() error {
+		key = peerKey{remoteAddr: remoteAddr, remoteRouterID: localSession.RemoteRouterID}
+		tracked = newTrackedConnection(outbound, cancel)
+		return resolveCollision(ctx, key, tracked, localSession.OwnRouterID, isNeighbor && neighbor.Active, localSession.HasGracefulRestart)
 	}
 
-	err := newBGPConnection(ctx, logger, conn, localSession)
+	err := newBGPConnection(ctx, logger, conn, localSession, openConfirm)
 	if err != nil {
 		if ctx.Err() != nil {
-			logger.Warn("connection initiation canceled")
+			logger.Warn("connection initiation canceled", "reason", context.Cause(ctx))
 			return
 		}
 		logger.Error("connection encountered an error during session initiation", "error", err.Error())
 		return
 	}
+	markEstablished(key, tracked)
+	established = true
 
-	t := table.NewPrefixTable(pathChangeChan, cancel)
+	t = takeRetainedTable(key)
+	if t != nil && localSession.HasGracefulRestart {
+		logger.Info("Resuming paths retained while the peer restarted")
+		t.Resume(cancel, localSession)
+	} else {
+		if t != nil {
+			logger.Info("Peer restarted without graceful restart, removed retained paths")
+			t.Discard()
+		}
+		t = table.NewPrefixTable(pathChangeChan, cancel, localSession)
+	}
+	t.SetImportWarning(localSession.ImportLimitWarning, func() {
+		logger.Warn("Import limit warning threshold exceeded", "count", t.ImportCount(), "limit", localSession.ImportLimit)
+		session.WarnImportLimit(localSession)
+	})
+	if localSession.HasGracefulRestart {
+		// Peers supporting graceful restart send End-of-RIB markers after the initial table dump
+		t.ExpectEndOfRIB(localSession.MultiProtocolAFIs)
+	}
 	wg.Go(func() {
 		table.ProcessUpdates(cancel, updateChannel, t)
 	})
 
-	session.AddSession(conn, localSession, t)
-	defer session.RemoveSession(conn)
+	session.AddSession(conn, localSession, t, cancel)
+	defer func() {
+		session.RemoveSession(localSession, closeReason)
+	}()
 
-	logger = logger.With("routerID", localSession.RemoteRouterID.String(), "hostname", localSession.RemoteHostname)
+	mrt.RecordStateChange(localSession, mrt.BgpStateOpenConfirm, mrt.BgpStateEstablished)
+	defer mrt.RecordStateChange(localSession, mrt.BgpStateEstablished, mrt.BgpStateIdle)
+
+	logger = logger.With("routerID", localSession.RemoteRouterID.String(), "hostname", localSession.RemoteHostname, "asn", localSession.RemoteAsn)
 
 	err = handleEstablished(ctx, cancel, conn, logger, localSession, updateChannel)
+	closeReason = err
+	if restart := importLimitRestartOf(neighbor, isNeighbor); restart > 0 && errors.Is(err, notification.ErrImportLimit) {
+		// Prevents the neighbor from immediately sending its full table again
+		session.BlockRemote(remoteAddr, restart)
+		logger.Warn("Refusing connections from the neighbor after it exceeded the import limit", "restart_in", restart)
+	}
 	if err != nil {
-		if !errors.Is(err, notification.ErrAdministrativeShutdown) {
+		if n, ok := errors.AsType[notification.Msg](err); ok && n.ErrorCode == notification.Cease {
+			logger.Info("connection closed by peer", "notification", n)
+		} else if !errors.Is(err, notification.ErrAdministrativeShutdown) {
 			logger.Error("connection encountered an error", "error", err.Error())
+		} else if reset, ok := errors.AsType[*notification.AdministrativeReset](err); ok {
+			logger.Info("connection closed due to local administrative reset", "message", reset.Message)
 		} else {
 			logger.Info("connection closed due to local administrative shutdown")
 		}
 	}
+	return
+}
+
+const (
+	defaultHoldTime = 240
+	defaultHostname = "flapalerted"
+)
 
+func applyNeighborSettings(localSession *common.LocalSession, neighbor config.Neighbor) {
+	if neighbor.AnyRemoteAsn {
+		localSession.ExpectedRemoteAsn = 0
+	} else if neighbor.RemoteAsn != 0 {
+		localSession.ExpectedRemoteAsn = neighbor.RemoteAsn
+	}
+	if neighbor.ImportLimit != nil {
+		localSession.ImportLimit = *neighbor.ImportLimit
+	}
+	if neighbor.AddPath != nil {
+		localSession.AddPathEnabled = *neighbor.AddPath
+	}
+	if neighbor.HoldTime != nil {
+		localSession.OwnHoldTime = *neighbor.HoldTime
+	}
+	if neighbor.Hostname != "" {
+		localSession.OwnHostname = neighbor.Hostname
+	}
+	localSession.Description = neighbor.Description
+	localSession.OwnRole = neighbor.Role
+	if neighbor.Announce != nil {
+		localSession.Announce = *neighbor.Announce && config.GlobalConf.AnnounceEnabled()
+	}
+}
+
+func importLimitWarningOf(neighbor config.Neighbor, isNeighbor bool) int {
+	if isNeighbor && neighbor.ImportLimitWarning != nil {
+		return *neighbor.ImportLimitWarning
+	}
+	return config.GlobalConf.ImportLimitWarning
+}
+
+func importLimitRestartOf(neighbor config.Neighbor, isNeighbor bool) time.Duration {
+	if isNeighbor && neighbor.ImportLimitRestart != nil {
+		return *neighbor.ImportLimitRestart
+	}
+	return config.GlobalConf.ImportLimitRestart
+}
+
+func remoteAddrOf(conn net.Conn) netip.Addr {
+	if tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return tcpAddr.AddrPort().Addr().Unmap()
+	}
+	return netip.Addr{}
+}
+
+func localAddrOf(conn net.Conn) netip.Addr {
+	if tcpAddr, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+		return tcpAddr.AddrPort().Addr().Unmap()
+	}
+	return netip.Addr{}
 }
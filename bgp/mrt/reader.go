@@ -0,0 +1,94 @@
+package mrt
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"time"
+)
+
+// openFile opens a plain, gzip or bzip2 compressed MRT file
+func openFile(name string) (io.Reader, io.Closer, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	br := bufio.NewReaderSize(f, 1<<16)
+	magic, err := br.Peek(3)
+	if err != nil && !errors.Is(err, io.EOF) {
+		_ = f.Close()
+		return nil, nil, err
+	}
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			_ = f.Close()
+			return nil, nil, fmt.Errorf("failed to open gzip stream: %w", err)
+		}
+		return bufio.NewReaderSize(gr, 1<<16), f, nil
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		return bufio.NewReaderSize(bzip2.NewReader(br), 1<<16), f, nil
+	}
+	return br, f, nil
+}
+
+// readRecord reads the next record. It returns io.EOF if no records are left.
+func readRecord(r io.Reader) (rec record, err error) {
+	var header recordHeader
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return rec, err
+	}
+	if header.Length > maxRecordLength {
+		return rec, fmt.Errorf("invalid MRT record length: %d", header.Length)
+	}
+	rec.Type = header.Type
+	rec.Subtype = header.Subtype
+	rec.Time = time.Unix(int64(header.Timestamp), 0)
+	rec.Body = make([]byte, header.Length)
+	if _, err := io.ReadFull(r, rec.Body); err != nil {
+		return rec, fmt.Errorf("truncated MRT record: %w", err)
+	}
+	if rec.Type == typeBGP4MPET {
+		if len(rec.Body) < 4 {
+			return rec, errors.New("truncated extended timestamp")
+		}
+		rec.Time = rec.Time.Add(time.Duration(binary.BigEndian.Uint32(rec.Body)) * time.Microsecond)
+		rec.Body = rec.Body[4:]
+		rec.Type = typeBGP4MP
+	}
+	return rec, nil
+}
+
+func readAddr(r io.Reader, ipv6 bool) (netip.Addr, error) {
+	if ipv6 {
+		var a [16]byte
+		if _, err := io.ReadFull(r, a[:]); err != nil {
+			return netip.Addr{}, err
+		}
+		return netip.AddrFrom16(a), nil
+	}
+	var a [4]byte
+	if _, err := io.ReadFull(r, a[:]); err != nil {
+		return netip.Addr{}, err
+	}
+	return netip.AddrFrom4(a), nil
+}
+
+func readAsn(r io.Reader, fourByte bool) (uint32, error) {
+	if fourByte {
+		var asn uint32
+		err := binary.Read(r, binary.BigEndian, &asn)
+		return asn, err
+	}
+	var asn uint16
+	err := binary.Read(r, binary.BigEndian, &asn)
+	return uint32(asn), err
+}
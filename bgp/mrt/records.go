@@ -0,0 +1,220 @@
+package mrt
+
+import (
+	"FlapAlerted/bgp/common"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Path attribute type codes used to rewrite the attributes of RIB entries
+const (
+	attrFlagsOptional              = 0x80
+	attrFlagsExtendedLength        = 0x10
+	multiProtocolReachableNLRIAttr = 14
+)
+
+func parseBGP4MPHeader(r io.Reader, fourByteAsn bool) (h bgp4mpHeader, err error) {
+	if h.PeerAsn, err = readAsn(r, fourByteAsn); err != nil {
+		return h, err
+	}
+	if h.LocalAsn, err = readAsn(r, fourByteAsn); err != nil {
+		return h, err
+	}
+	var interfaceIndex uint16
+	if err := binary.Read(r, binary.BigEndian, &interfaceIndex); err != nil {
+		return h, err
+	}
+	if err := binary.Read(r, binary.BigEndian, &h.AFI); err != nil {
+		return h, err
+	}
+	if h.AFI != common.AFI4 && h.AFI != common.AFI6 {
+		return h, fmt.Errorf("unknown address family %d", h.AFI)
+	}
+	if h.PeerAddr, err = readAddr(r, h.AFI == common.AFI6); err != nil {
+		return h, err
+	}
+	if h.LocalAddr, err = readAddr(r, h.AFI == common.AFI6); err != nil {
+		return h, err
+	}
+	return h, nil
+}
+
+// parseStateChange returns the old and new BGP finite state machine state
+func parseStateChange(r io.Reader) (oldState, newState uint16, err error) {
+	if err := binary.Read(r, binary.BigEndian, &oldState); err != nil {
+		return 0, 0, err
+	}
+	if err := binary.Read(r, binary.BigEndian, &newState); err != nil {
+		return 0, 0, err
+	}
+	return oldState, newState, nil
+}
+
+func parsePeerIndexTable(r io.Reader) ([]tableDumpPeer, error) {
+	var collectorID [4]byte
+	if _, err := io.ReadFull(r, collectorID[:]); err != nil {
+		return nil, err
+	}
+	var viewNameLength uint16
+	if err := binary.Read(r, binary.BigEndian, &viewNameLength); err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(io.Discard, r, int64(viewNameLength)); err != nil {
+		return nil, err
+	}
+	var peerCount uint16
+	if err := binary.Read(r, binary.BigEndian, &peerCount); err != nil {
+		return nil, err
+	}
+	peers := make([]tableDumpPeer, 0, peerCount)
+	for range peerCount {
+		var peerType uint8
+		if err := binary.Read(r, binary.BigEndian, &peerType); err != nil {
+			return nil, err
+		}
+		var peer tableDumpPeer
+		var err error
+		if peer.routerID, err = readAddr(r, false); err != nil {
+			return nil, err
+		}
+		if peer.key.address, err = readAddr(r, peerType&0x01 != 0); err != nil {
+			return nil, err
+		}
+		if peer.key.asn, err = readAsn(r, peerType&0x02 != 0); err != nil {
+			return nil, err
+		}
+		peers = append(peers, peer)
+	}
+	return peers, nil
+}
+
+type ribEntry struct {
+	peerIndex uint16
+	// Body of an UPDATE message announcing the prefix of the RIB entry
+	update []byte
+}
+
+// parseRIB returns the entries of a RIB record, converted to UPDATE messages
+func parseRIB(r io.Reader, afi common.AFI, addPath bool) ([]ribEntry, error) {
+	var sequence uint32
+	if err := binary.Read(r, binary.BigEndian, &sequence); err != nil {
+		return nil, err
+	}
+	var prefixLength uint8
+	if err := binary.Read(r, binary.BigEndian, &prefixLength); err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, (int(prefixLength)+7)/8)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, err
+	}
+	var entryCount uint16
+	if err := binary.Read(r, binary.BigEndian, &entryCount); err != nil {
+		return nil, err
+	}
+
+	entries := make([]ribEntry, 0, entryCount)
+	for range entryCount {
+		var entry ribEntry
+		if err := binary.Read(r, binary.BigEndian, &entry.peerIndex); err != nil {
+			return nil, err
+		}
+		var originatedTime uint32
+		if err := binary.Read(r, binary.BigEndian, &originatedTime); err != nil {
+			return nil, err
+		}
+		var nlri []byte
+		if addPath {
+			nlri = make([]byte, 4)
+			if _, err := io.ReadFull(r, nlri); err != nil {
+				return nil, err
+			}
+		}
+		nlri = append(nlri, prefixLength)
+		nlri = append(nlri, prefix...)
+
+		var attributesLength uint16
+		if err := binary.Read(r, binary.BigEndian, &attributesLength); err != nil {
+			return nil, err
+		}
+		attributes := make([]byte, attributesLength)
+		if _, err := io.ReadFull(r, attributes); err != nil {
+			return nil, err
+		}
+		var err error
+		entry.update, err = ribEntryToUpdate(attributes, afi, nlri)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// ribEntryToUpdate builds an UPDATE message body from the attributes of a RIB entry.
+// The MP_REACH_NLRI attribute of RIB entries only contains the next hop (RFC 6396 section 4.3.4)
+// and is therefore replaced by a complete attribute.
+func ribEntryToUpdate(attributes []byte, afi common.AFI, nlri []byte) ([]byte, error) {
+	var result bytes.Buffer
+	var rewritten bytes.Buffer
+	nextHop := []byte{0}
+
+	r := bytes.NewReader(attributes)
+	for r.Len() > 0 {
+		var header [2]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return nil, err
+		}
+		var length uint16
+		if header[0]&attrFlagsExtendedLength != 0 {
+			if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+				return nil, err
+			}
+		} else {
+			b, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			length = uint16(b)
+		}
+		body := make([]byte, length)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return nil, errors.New("truncated path attribute")
+		}
+		if header[1] == multiProtocolReachableNLRIAttr {
+			nextHop = body
+			continue
+		}
+		rewritten.Write(header[:])
+		if header[0]&attrFlagsExtendedLength != 0 {
+			_ = binary.Write(&rewritten, binary.BigEndian, length)
+		} else {
+			rewritten.WriteByte(uint8(length))
+		}
+		rewritten.Write(body)
+	}
+
+	if afi == common.AFI6 {
+		var mpReach bytes.Buffer
+		_ = binary.Write(&mpReach, binary.BigEndian, afi)
+		mpReach.WriteByte(byte(common.UNICAST))
+		mpReach.Write(nextHop)
+		mpReach.WriteByte(0) // Reserved
+		mpReach.Write(nlri)
+
+		rewritten.Write([]byte{attrFlagsOptional | attrFlagsExtendedLength, multiProtocolReachableNLRIAttr})
+		_ = binary.Write(&rewritten, binary.BigEndian, uint16(mpReach.Len()))
+		rewritten.Write(mpReach.Bytes())
+	}
+
+	_ = binary.Write(&result, binary.BigEndian, uint16(0)) // Withdrawn routes length
+	_ = binary.Write(&result, binary.BigEndian, uint16(rewritten.Len()))
+	result.Write(rewritten.Bytes())
+	if afi == common.AFI4 {
+		result.Write(nlri)
+	}
+	return result.Bytes(), nil
+}
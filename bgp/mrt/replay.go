@@ -0,0 +1,304 @@
+package mrt
+
+import (
+	"FlapAlerted/bgp/common"
+	"FlapAlerted/bgp/table"
+	"FlapAlerted/bgp/update"
+	"FlapAlerted/config"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+- RFCs implemented:
+- "Multi-Threaded Routing Toolkit (MRT) Routing Information Export Format" https://datatracker.ietf.org/doc/html/rfc6396
+- "Multi-Threaded Routing Toolkit (MRT) Routing Information Export Format with BGP Additional Path Extensions" https://datatracker.ietf.org/doc/html/rfc8050
+*/
+
+type replayPeer struct {
+	session *common.LocalSession
+	table   *table.PrefixTable
+	ctx     context.Context
+}
+
+type replayer struct {
+	pathChangeChan chan table.PathChange
+	clock          chan time.Time
+	speed          float64
+	interval       time.Duration
+
+	peers     map[peerKey]*replayPeer
+	peerIndex []tableDumpPeer
+	// Peers that exceeded the import limit, ignored until their next session
+	disabledPeers map[peerKey]struct{}
+
+	startTime     time.Time
+	wallStartTime time.Time
+	nextTick      time.Time
+	lastTime      time.Time
+
+	recordCount      uint64
+	updateCount      uint64
+	invalidCount     uint64
+	unsupportedCount uint64
+}
+
+// StartReplay replays the updates contained in the given MRT files in place of BGP sessions.
+// Every peer of the collector that wrote the files is treated like a separate session with its own table.
+// The returned clock channel marks the end of each analysis interval, based on the timestamps in the files.
+// Speed is the factor by which the replay runs faster than real time, zero replays as fast as possible.
+// After the replay has finished, its results remain available until the context is canceled.
+func StartReplay(ctx context.Context, parentWg *sync.WaitGroup, files []string, speed float64, interval time.Duration) (<-chan table.PathChange, <-chan time.Time, error) {
+	for _, name := range files {
+		if _, err := os.Stat(name); err != nil {
+			return nil, nil, fmt.Errorf("failed to open MRT file: %w", err)
+		}
+	}
+
+	r := &replayer{
+		// Unbuffered so that every path change is received by the analyzer before the next clock tick
+		pathChangeChan: make(chan table.PathChange),
+		clock:          make(chan time.Time),
+		speed:          speed,
+		interval:       interval,
+		peers:          make(map[peerKey]*replayPeer),
+		disabledPeers:  make(map[peerKey]struct{}),
+	}
+	parentWg.Go(func() {
+		defer close(r.pathChangeChan)
+
+		for _, name := range files {
+			if err := r.replayFile(ctx, name); err != nil {
+				if ctx.Err() != nil {
+					slog.Info("MRT replay stopped", "reason", ctx.Err())
+					return
+				}
+				slog.Error("Failed to replay MRT file", "file", name, "error", err)
+			}
+		}
+		replayedDuration := r.lastTime.Sub(r.startTime)
+		if !r.startTime.IsZero() {
+			// Ends the interval containing the last record, so that it is analyzed as well
+			if err := r.advance(ctx, r.nextTick); err != nil {
+				slog.Info("MRT replay stopped", "reason", err)
+				return
+			}
+		}
+		slog.Info("MRT replay finished", "records", r.recordCount, "updates", r.updateCount,
+			"invalid", r.invalidCount, "unsupported", r.unsupportedCount,
+			"replayed_duration", replayedDuration, "duration", time.Since(r.wallStartTime))
+		<-ctx.Done()
+	})
+	return r.pathChangeChan, r.clock, nil
+}
+
+func (r *replayer) replayFile(ctx context.Context, name string) error {
+	reader, closer, err := openFile(name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = closer.Close()
+	}()
+	slog.Info("Replaying MRT file", "file", name)
+
+	for {
+		rec, err := readRecord(reader)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		r.recordCount++
+		if err := r.advance(ctx, rec.Time); err != nil {
+			return err
+		}
+		if err := r.handleRecord(rec); err != nil {
+			r.invalidCount++
+			slog.Debug("Invalid MRT record", "type", rec.Type, "subtype", rec.Subtype, "error", err)
+		}
+	}
+}
+
+// advance waits until the given time of the replay is reached and ends analysis intervals that have passed
+func (r *replayer) advance(ctx context.Context, t time.Time) error {
+	if r.startTime.IsZero() {
+		r.startTime = t
+		r.wallStartTime = time.Now()
+		r.nextTick = t.Add(r.interval)
+		// Sets the start time of the analyzer
+		select {
+		case r.clock <- t:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if r.speed > 0 {
+		wallTime := r.wallStartTime.Add(time.Duration(float64(t.Sub(r.startTime)) / r.speed))
+		if wait := time.Until(wallTime); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			}
+		}
+	}
+
+	r.lastTime = t
+
+	for !t.Before(r.nextTick) {
+		select {
+		case r.clock <- r.nextTick:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		r.nextTick = r.nextTick.Add(r.interval)
+	}
+	return ctx.Err()
+}
+
+func (r *replayer) handleRecord(rec record) error {
+	reader := bytes.NewReader(rec.Body)
+	switch rec.Type {
+	case typeTableDumpV2:
+		switch rec.Subtype {
+		case tableDumpPeerIndexTable:
+			peers, err := parsePeerIndexTable(reader)
+			if err != nil {
+				return fmt.Errorf("failed parsing peer index table: %w", err)
+			}
+			r.peerIndex = peers
+			return nil
+		case tableDumpRIBIPv4Unicast, tableDumpRIBIPv6Unicast, tableDumpRIBIPv4UnicastAddPath, tableDumpRIBIPv6UnicastAddPath:
+			afi := common.AFI4
+			if rec.Subtype == tableDumpRIBIPv6Unicast || rec.Subtype == tableDumpRIBIPv6UnicastAddPath {
+				afi = common.AFI6
+			}
+			addPath := rec.Subtype == tableDumpRIBIPv4UnicastAddPath || rec.Subtype == tableDumpRIBIPv6UnicastAddPath
+			entries, err := parseRIB(reader, afi, addPath)
+			if err != nil {
+				return fmt.Errorf("failed parsing RIB entry: %w", err)
+			}
+			for _, entry := range entries {
+				if int(entry.peerIndex) >= len(r.peerIndex) {
+					return fmt.Errorf("unknown peer index %d", entry.peerIndex)
+				}
+				peer := r.peerIndex[entry.peerIndex]
+				msg, err := update.DecodeMsgUpdate(entry.update, common.AFI4, addPath)
+				if err != nil {
+					return fmt.Errorf("failed parsing RIB entry attributes: %w", err)
+				}
+				// Paths of table dumps always use four-octet ASNs
+				if err := r.processUpdate(peer.key, peer.routerID, msg, addPath, false); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case typeBGP4MP:
+		switch rec.Subtype {
+		case bgp4mpStateChange, bgp4mpStateChangeAS4:
+			header, err := parseBGP4MPHeader(reader, rec.Subtype == bgp4mpStateChangeAS4)
+			if err != nil {
+				return fmt.Errorf("failed parsing state change: %w", err)
+			}
+			oldState, newState, err := parseStateChange(reader)
+			if err != nil {
+				return fmt.Errorf("failed parsing state change: %w", err)
+			}
+			if oldState == bgpStateEstablished || newState == bgpStateEstablished {
+				// The paths of the previous session are no longer valid
+				r.removePeer(peerKey{address: header.PeerAddr, asn: header.PeerAsn})
+			}
+			return nil
+		case bgp4mpMessage, bgp4mpMessageAS4, bgp4mpMessageAddPath, bgp4mpMessageAS4AddPath:
+			fourByteAsn := rec.Subtype == bgp4mpMessageAS4 || rec.Subtype == bgp4mpMessageAS4AddPath
+			addPath := rec.Subtype == bgp4mpMessageAddPath || rec.Subtype == bgp4mpMessageAS4AddPath
+			header, err := parseBGP4MPHeader(reader, fourByteAsn)
+			if err != nil {
+				return fmt.Errorf("failed parsing BGP4MP message: %w", err)
+			}
+			msgHeader, body, err := common.SliceMessage(rec.Body[len(rec.Body)-reader.Len():])
+			if err != nil {
+				return fmt.Errorf("failed parsing BGP4MP message: %w", err)
+			}
+			if msgHeader.BgpType != common.MsgUpdate {
+				return nil
+			}
+			u, err := update.DecodeMsgUpdate(body, common.AFI4, addPath)
+			if err != nil {
+				return fmt.Errorf("failed parsing UPDATE message: %w", err)
+			}
+			return r.processUpdate(peerKey{address: header.PeerAddr, asn: header.PeerAsn}, netip.Addr{}, u, addPath, !fourByteAsn)
+		case bgp4mpMessageLocal, bgp4mpMessageAS4Local, bgp4mpMessageLocalAddPath, bgp4mpMessageAS4LocalAP:
+			// Messages sent by the collector
+			return nil
+		}
+	}
+	r.unsupportedCount++
+	return nil
+}
+
+func (r *replayer) processUpdate(key peerKey, routerID netip.Addr, msg update.Msg, addPath, twoByteAsPath bool) error {
+	if _, disabled := r.disabledPeers[key]; disabled {
+		return nil
+	}
+	p, found := r.peers[key]
+	if !found {
+		p = r.addPeer(key, routerID)
+	}
+	// Updates are processed synchronously, the encoding of each message is determined by its record
+	p.session.AddPathEnabled = addPath
+	p.session.TwoByteAsPath = twoByteAsPath
+
+	r.updateCount++
+	err := p.table.ProcessUpdate(table.SessionUpdateMessage{Msg: msg, Session: p.session})
+	if p.ctx.Err() != nil {
+		slog.Warn("Updates of peer are ignored until its next session", "peer", key.address, "asn", key.asn, "reason", context.Cause(p.ctx))
+		r.removePeer(key)
+		r.disabledPeers[key] = struct{}{}
+	}
+	return err
+}
+
+func (r *replayer) addPeer(key peerKey, routerID netip.Addr) *replayPeer {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	localSession := &common.LocalSession{
+		DefaultAFI:        common.AFI4,
+		RemoteAsn:         key.asn,
+		RemoteAddress:     key.address,
+		RemoteRouterID:    routerID,
+		ImportLimit:       config.GlobalConf.ImportLimit,
+		MultiProtocolAFIs: []common.AFI{common.AFI4, common.AFI6},
+		// Next hops of IPv4 routes are only treated as IPv6 addresses if their length requires it
+		HasExtendedNextHopV4: true,
+	}
+	p := &replayPeer{
+		session: localSession,
+		table:   table.NewPrefixTable(r.pathChangeChan, cancel, localSession),
+		ctx:     ctx,
+	}
+	r.peers[key] = p
+	slog.Debug("Replaying updates of new peer", "peer", key.address, "asn", key.asn)
+	return p
+}
+
+func (r *replayer) removePeer(key peerKey) {
+	if p, found := r.peers[key]; found {
+		p.table.Discard()
+	}
+	delete(r.peers, key)
+	delete(r.disabledPeers, key)
+}
@@ -0,0 +1,79 @@
+package mrt
+
+import (
+	"FlapAlerted/bgp/common"
+	"net/netip"
+	"time"
+)
+
+type recordType uint16
+
+const (
+	typeTableDumpV2 recordType = 13
+	typeBGP4MP      recordType = 16
+	typeBGP4MPET    recordType = 17 // BGP4MP with microsecond timestamps
+)
+
+type subtype uint16
+
+// BGP4MP subtypes
+const (
+	bgp4mpStateChange         subtype = 0
+	bgp4mpMessage             subtype = 1
+	bgp4mpMessageAS4          subtype = 4
+	bgp4mpStateChangeAS4      subtype = 5
+	bgp4mpMessageLocal        subtype = 6
+	bgp4mpMessageAS4Local     subtype = 7
+	bgp4mpMessageAddPath      subtype = 8  // RFC 8050
+	bgp4mpMessageAS4AddPath   subtype = 9  // RFC 8050
+	bgp4mpMessageLocalAddPath subtype = 10 // RFC 8050
+	bgp4mpMessageAS4LocalAP   subtype = 11 // RFC 8050
+)
+
+// TABLE_DUMP_V2 subtypes
+const (
+	tableDumpPeerIndexTable        subtype = 1
+	tableDumpRIBIPv4Unicast        subtype = 2
+	tableDumpRIBIPv6Unicast        subtype = 4
+	tableDumpRIBIPv4UnicastAddPath subtype = 8  // RFC 8050
+	tableDumpRIBIPv6UnicastAddPath subtype = 10 // RFC 8050
+)
+
+// BGP finite state machine state of BGP4MP state changes
+const bgpStateEstablished = 6
+
+type recordHeader struct {
+	Timestamp uint32
+	Type      recordType
+	Subtype   subtype
+	Length    uint32
+}
+
+// Upper bound for the length of a single record
+const maxRecordLength = 1 << 24
+
+type record struct {
+	Time    time.Time
+	Type    recordType
+	Subtype subtype
+	Body    []byte
+}
+
+// Identifies a peer of the collector that has written the file
+type peerKey struct {
+	address netip.Addr
+	asn     uint32
+}
+
+type tableDumpPeer struct {
+	key      peerKey
+	routerID netip.Addr
+}
+
+type bgp4mpHeader struct {
+	PeerAsn   uint32
+	LocalAsn  uint32
+	AFI       common.AFI
+	PeerAddr  netip.Addr
+	LocalAddr netip.Addr
+}
@@ -0,0 +1,219 @@
+package mrt
+
+import (
+	"FlapAlerted/bgp/common"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BGP finite state machine states of recorded state changes
+const (
+	BgpStateIdle        uint16 = 1
+	BgpStateOpenConfirm uint16 = 5
+	BgpStateEstablished uint16 = bgpStateEstablished
+)
+
+// Maximum number of records waiting to be written. Further records are dropped.
+const recordQueueSize = 4096
+
+const recordFlushInterval = 5 * time.Second
+
+var (
+	recordQueue      chan []byte
+	recordingEnabled atomic.Bool
+	droppedRecords   atomic.Uint64
+)
+
+// StartRecording writes the updates received by BGP sessions to files in the given directory.
+// A new file is started every rotation interval.
+func StartRecording(ctx context.Context, wg *sync.WaitGroup, directory string, rotation time.Duration) error {
+	if err := os.MkdirAll(directory, 0o755); err != nil {
+		return fmt.Errorf("failed to create MRT record directory: %w", err)
+	}
+	recordQueue = make(chan []byte, recordQueueSize)
+	recordingEnabled.Store(true)
+
+	wg.Go(func() {
+		w := &recordWriter{directory: directory, rotation: rotation}
+		defer w.close()
+
+		flushTicker := time.NewTicker(recordFlushInterval)
+		defer flushTicker.Stop()
+		var reportedDrops uint64
+		for {
+			select {
+			case <-ctx.Done():
+				recordingEnabled.Store(false)
+				// Write remaining records
+				for {
+					select {
+					case rec := <-recordQueue:
+						w.write(rec)
+					default:
+						return
+					}
+				}
+			case rec := <-recordQueue:
+				w.write(rec)
+			case <-flushTicker.C:
+				w.flush()
+				if dropped := droppedRecords.Load(); dropped != reportedDrops {
+					slog.Warn("MRT records dropped as writing did not keep up", "count", dropped-reportedDrops)
+					reportedDrops = dropped
+				}
+			}
+		}
+	})
+	return nil
+}
+
+// RecordingEnabled returns true if received updates are recorded
+func RecordingEnabled() bool {
+	return recordingEnabled.Load()
+}
+
+// RecordUpdate records an UPDATE message, given without its header, received by the given session.
+// It never blocks, records are dropped if they cannot be written in time.
+func RecordUpdate(session *common.LocalSession, body []byte) {
+	subtype := bgp4mpMessageAS4
+	if session.AddPathEnabled {
+		subtype = bgp4mpMessageAS4AddPath
+	}
+	var buf bytes.Buffer
+	buf.Grow(commonBGP4MPLength + 19 + len(body))
+	writeBGP4MPHeader(&buf, session)
+	buf.Write(bytes.Repeat([]byte{0xff}, 16))
+	_ = binary.Write(&buf, binary.BigEndian, uint16(19+len(body)))
+	buf.WriteByte(byte(common.MsgUpdate))
+	buf.Write(body)
+	enqueueRecord(subtype, buf.Bytes())
+}
+
+// RecordStateChange records a state change of the given session
+func RecordStateChange(session *common.LocalSession, oldState, newState uint16) {
+	if !recordingEnabled.Load() {
+		return
+	}
+	var buf bytes.Buffer
+	writeBGP4MPHeader(&buf, session)
+	_ = binary.Write(&buf, binary.BigEndian, oldState)
+	_ = binary.Write(&buf, binary.BigEndian, newState)
+	enqueueRecord(bgp4mpStateChangeAS4, buf.Bytes())
+}
+
+// Length of the BGP4MP header for IPv6 sessions
+const commonBGP4MPLength = 4 + 4 + 2 + 2 + 16 + 16
+
+func writeBGP4MPHeader(buf *bytes.Buffer, session *common.LocalSession) {
+	remote, local := session.RemoteAddress.Unmap(), session.LocalAddress.Unmap()
+	afi := common.AFI4
+	if remote.Is6() || local.Is6() {
+		afi = common.AFI6
+		remote, local = as16(remote), as16(local)
+	} else {
+		remote, local = as4(remote), as4(local)
+	}
+	_ = binary.Write(buf, binary.BigEndian, session.RemoteAsn)
+	_ = binary.Write(buf, binary.BigEndian, session.Asn)
+	_ = binary.Write(buf, binary.BigEndian, uint16(0)) // Interface index
+	_ = binary.Write(buf, binary.BigEndian, afi)
+	buf.Write(remote.AsSlice())
+	buf.Write(local.AsSlice())
+}
+
+func enqueueRecord(subtype subtype, body []byte) {
+	now := time.Now()
+	rec := make([]byte, 0, 16+len(body))
+	rec = binary.BigEndian.AppendUint32(rec, uint32(now.Unix()))
+	rec = binary.BigEndian.AppendUint16(rec, uint16(typeBGP4MPET))
+	rec = binary.BigEndian.AppendUint16(rec, uint16(subtype))
+	rec = binary.BigEndian.AppendUint32(rec, uint32(4+len(body)))
+	rec = binary.BigEndian.AppendUint32(rec, uint32(now.Nanosecond()/1000))
+	rec = append(rec, body...)
+	select {
+	case recordQueue <- rec:
+	default:
+		droppedRecords.Add(1)
+	}
+}
+
+type recordWriter struct {
+	directory string
+	rotation  time.Duration
+	file      *os.File
+	writer    *bufio.Writer
+	fileStart time.Time
+}
+
+func (w *recordWriter) write(rec []byte) {
+	// Records are written to the file of the interval in which they were received
+	recordTime := time.Unix(int64(binary.BigEndian.Uint32(rec)), 0)
+	if start := recordTime.Truncate(w.rotation); w.file == nil || start.After(w.fileStart) {
+		w.close()
+		if err := w.open(start); err != nil {
+			slog.Error("Failed to open MRT record file", "error", err)
+			return
+		}
+	}
+	if _, err := w.writer.Write(rec); err != nil {
+		slog.Error("Failed to write MRT record", "error", err)
+	}
+}
+
+func (w *recordWriter) open(start time.Time) error {
+	name := filepath.Join(w.directory, "updates."+start.UTC().Format("20060102.1504")+".mrt")
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	w.file = f
+	w.writer = bufio.NewWriterSize(f, 1<<16)
+	w.fileStart = start
+	slog.Debug("Started MRT record file", "file", name)
+	return nil
+}
+
+func (w *recordWriter) flush() {
+	if w.writer == nil {
+		return
+	}
+	if err := w.writer.Flush(); err != nil {
+		slog.Error("Failed to write MRT records", "error", err)
+	}
+}
+
+func (w *recordWriter) close() {
+	if w.file == nil {
+		return
+	}
+	w.flush()
+	if err := w.file.Close(); err != nil {
+		slog.Error("Failed to close MRT record file", "error", err)
+	}
+	w.file = nil
+	w.writer = nil
+}
+
+func as16(a netip.Addr) netip.Addr {
+	if !a.IsValid() {
+		return netip.IPv6Unspecified()
+	}
+	return netip.AddrFrom16(a.As16())
+}
+
+func as4(a netip.Addr) netip.Addr {
+	if !a.IsValid() {
+		return netip.AddrFrom4([4]byte{})
+	}
+	return a
+}
@@ -0,0 +1,64 @@
+package notification
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+/*
+- "BGP Administrative Shutdown Communication" https://datatracker.ietf.org/doc/html/rfc9003
+*/
+
+// Maximum length in bytes of a Shutdown Communication
+const MaxShutdownCommunicationLength = 255
+
+// ShutdownCommunication returns the operator message of an Administrative Shutdown or Administrative Reset Cease.
+// False is returned if the message carries no valid Shutdown Communication.
+func (m Msg) ShutdownCommunication() (string, bool) {
+	if m.ErrorCode != Cease || (m.ErrorSubCode != CeaseAdministrativeShutdown && m.ErrorSubCode != CeaseAdministrativeReset) {
+		return "", false
+	}
+	if len(m.ErrorData) == 0 {
+		return "", false
+	}
+	length := int(m.ErrorData[0])
+	if length > len(m.ErrorData)-1 {
+		return "", false
+	}
+	text := m.ErrorData[1 : 1+length]
+	if !utf8.Valid(text) {
+		return "", false
+	}
+	return string(text), true
+}
+
+// ShutdownCommunicationData returns the data field of a Cease NOTIFICATION message carrying the given Shutdown Communication.
+// Messages that are too long are truncated without splitting UTF-8 characters. An empty message results in an empty data field.
+func ShutdownCommunicationData(message string) []byte {
+	if message == "" {
+		return []byte{}
+	}
+	for len(message) > MaxShutdownCommunicationLength {
+		_, size := utf8.DecodeLastRuneInString(message)
+		message = message[:len(message)-size]
+	}
+	return append([]byte{uint8(len(message))}, message...)
+}
+
+// AdministrativeReset is the cause of a session closed by an operator.
+// It matches ErrAdministrativeShutdown, but the session is closed with an Administrative Reset Cease.
+type AdministrativeReset struct {
+	// Shutdown Communication sent to the peer
+	Message string
+}
+
+func (r *AdministrativeReset) Error() string {
+	if r.Message != "" {
+		return fmt.Sprintf("administrative session reset: %q", r.Message)
+	}
+	return "administrative session reset"
+}
+
+func (r *AdministrativeReset) Unwrap() error {
+	return ErrAdministrativeShutdown
+}
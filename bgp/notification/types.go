@@ -26,6 +26,10 @@ const (
 )
 
 func (m Msg) Error() string {
+	if text, ok := m.ShutdownCommunication(); ok && text != "" {
+		return fmt.Sprintf("BGP %s error (subcode=%d): %q",
+			m.ErrorCode.String(), m.ErrorSubCode, text)
+	}
 	return fmt.Sprintf("BGP %s error (subcode=%d)",
 		m.ErrorCode.String(), m.ErrorSubCode)
 }
@@ -53,24 +57,37 @@ const (
 	OpenBadPeerAS                    ErrorSubCode = 2
 	OpenUnsupportedOptionalParameter ErrorSubCode = 4
 	OpenUnacceptableHoldTime         ErrorSubCode = 6
+	OpenRoleMismatch                 ErrorSubCode = 11
 )
 
 const (
 	UpdateMessageErrorUnspecific ErrorSubCode = 0
+	UpdateMalformedAttributeList ErrorSubCode = 1
+	UpdateAttributeFlagsError    ErrorSubCode = 4
+	UpdateAttributeLengthError   ErrorSubCode = 5
+	UpdateOptionalAttributeError ErrorSubCode = 9
+	UpdateInvalidNetworkField    ErrorSubCode = 10
 )
 
 const (
-	CeaseMaxNumberOfPrefixes    ErrorSubCode = 1
-	CeaseAdministrativeShutdown ErrorSubCode = 2
+	CeaseMaxNumberOfPrefixes           ErrorSubCode = 1
+	CeaseAdministrativeShutdown        ErrorSubCode = 2
+	CeaseAdministrativeReset           ErrorSubCode = 4
+	CeaseConnectionCollisionResolution ErrorSubCode = 7
 )
 
 func (m Msg) LogValue() slog.Value {
-	return slog.GroupValue(
+	attrs := []slog.Attr{
 		slog.String("error_code", m.ErrorCode.String()),
 		slog.Int("error_sub_code", int(m.ErrorSubCode)),
-	)
+	}
+	if text, ok := m.ShutdownCommunication(); ok {
+		attrs = append(attrs, slog.String("shutdown_communication", text))
+	}
+	return slog.GroupValue(attrs...)
 }
 
 var ErrImportLimit = errors.New("import limit reached")
 var ErrAdministrativeShutdown = errors.New("administrative session shutdown")
 var ErrHoldTimeExpired = errors.New("hold timer expired")
+var ErrConnectionCollision = errors.New("connection collision resolution")
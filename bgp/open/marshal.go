@@ -177,6 +177,30 @@ func (c ExtendedNextHopCapability) MarshalBinary() ([]byte, error) {
 	return b.Bytes(), nil
 }
 
+func (c GracefulRestartCapability) MarshalBinary() ([]byte, error) {
+	if c.RestartTime > 0x0FFF {
+		return nil, fmt.Errorf("graceful restart time too large: %d", c.RestartTime)
+	}
+	flagsAndTime := c.RestartTime
+	if c.RestartState {
+		flagsAndTime |= 0x8000
+	}
+	b := binary.BigEndian.AppendUint16(make([]byte, 0, 2+4*len(c.Tuples)), flagsAndTime)
+	for _, t := range c.Tuples {
+		var flags uint8
+		if t.ForwardingStatePreserved {
+			flags |= 0x80
+		}
+		b = binary.BigEndian.AppendUint16(b, uint16(t.AFI))
+		b = append(b, uint8(t.SAFI), flags)
+	}
+	return b, nil
+}
+
+func (c RoleCapability) MarshalBinary() ([]byte, error) {
+	return []byte{uint8(c.Role)}, nil
+}
+
 func (c UnknownCapability) MarshalBinary() ([]byte, error) {
 	return c.Value, nil
 }
@@ -158,6 +158,42 @@ func parseCapabilityParameter(r io.Reader) (result CapabilityList, err error) {
 				list = append(list, t)
 			}
 			p.CapabilityValue = list
+		case CapabilityCodeGracefulRestart:
+			t := GracefulRestartCapability{}
+			var flagsAndTime uint16
+			if err := binary.Read(cr, binary.BigEndian, &flagsAndTime); err != nil {
+				return result, err
+			}
+			t.RestartState = flagsAndTime&0x8000 != 0
+			t.RestartTime = flagsAndTime & 0x0FFF
+			for {
+				var tuple struct {
+					AFI   common.AFI
+					SAFI  common.SAFI
+					Flags uint8
+				}
+				if err := binary.Read(cr, binary.BigEndian, &tuple); err != nil {
+					if errors.Is(err, io.EOF) {
+						break
+					}
+					return result, err
+				}
+				t.Tuples = append(t.Tuples, GracefulRestartTuple{
+					AFI:                      tuple.AFI,
+					SAFI:                     tuple.SAFI,
+					ForwardingStatePreserved: tuple.Flags&0x80 != 0,
+				})
+			}
+			p.CapabilityValue = t
+		case CapabilityCodeRole:
+			t := RoleCapability{}
+			if p.CapabilityLength != 1 {
+				return result, fmt.Errorf("invalid role capability length %d", p.CapabilityLength)
+			}
+			if err := binary.Read(cr, binary.BigEndian, &t.Role); err != nil {
+				return result, err
+			}
+			p.CapabilityValue = t
 		default:
 			t := UnknownCapability{}
 			t.Value = make([]byte, p.CapabilityLength)
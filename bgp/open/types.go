@@ -52,6 +52,8 @@ const (
 	CapabilityCodeExtendedMessage CapabilityCode = 6
 	CapabilityCodeHostname        CapabilityCode = 73
 	CapabilityCodeExtendedNextHop CapabilityCode = 5
+	CapabilityCodeGracefulRestart CapabilityCode = 64
+	CapabilityCodeRole            CapabilityCode = 9
 )
 
 type CapabilityValue interface {
@@ -99,6 +101,22 @@ type ExtendedNextHopCapability struct {
 	NextHopAFI common.AFI
 }
 
+type GracefulRestartCapability struct {
+	RestartState bool
+	RestartTime  uint16 // 12 bits
+	Tuples       []GracefulRestartTuple
+}
+
+type GracefulRestartTuple struct {
+	AFI                      common.AFI
+	SAFI                     common.SAFI
+	ForwardingStatePreserved bool
+}
+
+type RoleCapability struct {
+	Role common.Role
+}
+
 type UnknownCapability struct {
 	Value []byte
 }
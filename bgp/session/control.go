@@ -0,0 +1,91 @@
+package session
+
+import (
+	"FlapAlerted/bgp/notification"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ResetSessions closes the established sessions with the given remote address with an Administrative Reset
+// carrying the given Shutdown Communication. Sessions monitored via BMP are not affected.
+// Returns the number of sessions that have been reset.
+func ResetSessions(remote netip.Addr, message string) int {
+	sessionTrackerLock.RLock()
+	defer sessionTrackerLock.RUnlock()
+	count := 0
+	for _, session := range sessionTracker {
+		if session.cancel == nil || session.remoteAddr != remote.Unmap() {
+			continue
+		}
+		session.cancel(&notification.AdministrativeReset{Message: message})
+		count++
+	}
+	return count
+}
+
+// Remotes that are not allowed to establish sessions
+var (
+	blockedRemotes     = make(map[netip.Addr]time.Time)
+	blockedRemotesLock sync.Mutex
+)
+
+// Block prevents a remote from establishing sessions until it expires
+type Block struct {
+	Remote netip.Addr
+	Until  int64
+}
+
+// BlockRemote rejects connections from and to the remote address for the given duration.
+// An existing block of the remote is replaced.
+func BlockRemote(remote netip.Addr, duration time.Duration) Block {
+	until := time.Now().Add(duration)
+	blockedRemotesLock.Lock()
+	defer blockedRemotesLock.Unlock()
+	blockedRemotes[remote.Unmap()] = until
+	return Block{Remote: remote.Unmap(), Until: until.Unix()}
+}
+
+// UnblockRemote removes the block of the remote address. Returns false if the remote was not blocked.
+func UnblockRemote(remote netip.Addr) bool {
+	blockedRemotesLock.Lock()
+	defer blockedRemotesLock.Unlock()
+	_, found := blockedRemotes[remote.Unmap()]
+	delete(blockedRemotes, remote.Unmap())
+	return found
+}
+
+// IsBlocked returns true if the remote address is currently blocked
+func IsBlocked(remote netip.Addr) bool {
+	blockedRemotesLock.Lock()
+	defer blockedRemotesLock.Unlock()
+	until, found := blockedRemotes[remote.Unmap()]
+	if !found {
+		return false
+	}
+	if time.Now().After(until) {
+		delete(blockedRemotes, remote.Unmap())
+		return false
+	}
+	return true
+}
+
+// GetBlockedRemotes returns the blocks that have not yet expired, sorted by remote address
+func GetBlockedRemotes() []Block {
+	blockedRemotesLock.Lock()
+	defer blockedRemotesLock.Unlock()
+	now := time.Now()
+	blocks := make([]Block, 0, len(blockedRemotes))
+	for remote, until := range blockedRemotes {
+		if now.After(until) {
+			delete(blockedRemotes, remote)
+			continue
+		}
+		blocks = append(blocks, Block{Remote: remote, Until: until.Unix()})
+	}
+	slices.SortFunc(blocks, func(a, b Block) int {
+		return a.Remote.Compare(b.Remote)
+	})
+	return blocks
+}
@@ -0,0 +1,74 @@
+package session
+
+import (
+	"FlapAlerted/bgp/notification"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type EventType uint8
+
+const (
+	// EventEstablished is sent when a session has been established
+	EventEstablished EventType = iota + 1
+	// EventClosed is sent when a session has been closed
+	EventClosed
+	// EventImportLimit is sent instead of EventClosed when a session has been closed because it exceeded its import limit
+	EventImportLimit
+	// EventImportLimitWarning is sent when the number of routes of a session exceeds its warning threshold
+	EventImportLimitWarning
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventEstablished:
+		return "established"
+	case EventClosed:
+		return "closed"
+	case EventImportLimit:
+		return "import_limit"
+	case EventImportLimitWarning:
+		return "import_limit_warning"
+	}
+	return fmt.Sprintf("unknown (%d)", uint8(t))
+}
+
+func (t EventType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// Event is a change of the state of a session
+type Event struct {
+	Type    EventType
+	Time    int64
+	Session Info
+	// Only set for closed sessions
+	Close *Closure `json:",omitempty"`
+}
+
+// Events are dropped if they are not received in time
+var events = make(chan Event, 100)
+
+// Events returns the channel that receives the session events
+func Events() <-chan Event {
+	return events
+}
+
+func sendEvent(e Event) {
+	select {
+	case events <- e:
+	default:
+	}
+}
+
+func closeEventType(reason error) EventType {
+	if errors.Is(reason, notification.ErrImportLimit) {
+		return EventImportLimit
+	}
+	return EventClosed
+}
+
+func newEvent(t EventType, info Info) Event {
+	return Event{Type: t, Time: time.Now().Unix(), Session: info}
+}
@@ -0,0 +1,108 @@
+package session
+
+import (
+	"FlapAlerted/bgp/notification"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+var (
+	// Most recent session closure by peer
+	lastClosures = make(map[peerKey]Closure)
+	// Past sessions, newest first
+	history     = make([]PastSession, 0, maxHistory)
+	historyLock sync.Mutex
+)
+
+const (
+	// Maximum number of peers for which the most recent session closure is kept
+	maxClosures = 1000
+	// Maximum number of past sessions kept
+	maxHistory = 100
+)
+
+type peerKey struct {
+	bmpRouter  string
+	remoteAddr netip.Addr
+}
+
+type Closure struct {
+	Time   int64
+	Reason string
+	// Shutdown Communication of the NOTIFICATION message received from the peer (RFC 9003)
+	ShutdownCommunication string `json:",omitempty"`
+}
+
+// PastSession is a session that has been closed, along with its state at the time it was closed
+type PastSession struct {
+	Info
+	Close Closure
+}
+
+func recordClosure(s establishedSession, reason error) {
+	c := Closure{
+		Time:   time.Now().Unix(),
+		Reason: "closed",
+	}
+	if reason != nil {
+		c.Reason = reason.Error()
+	}
+	if n, ok := errors.AsType[notification.Msg](reason); ok {
+		c.ShutdownCommunication, _ = n.ShutdownCommunication()
+	}
+	info := newInfo(s)
+	event := newEvent(closeEventType(reason), info)
+	event.Close = &c
+	sendEvent(event)
+
+	historyLock.Lock()
+	defer historyLock.Unlock()
+	key := peerKey{bmpRouter: s.BMPRouter, remoteAddr: s.remoteAddr}
+	if _, exists := lastClosures[key]; !exists && len(lastClosures) >= maxClosures {
+		// Forget the oldest closure
+		var oldest peerKey
+		oldestTime := int64(math.MaxInt64)
+		for k, v := range lastClosures {
+			if v.Time < oldestTime {
+				oldest, oldestTime = k, v.Time
+			}
+		}
+		delete(lastClosures, oldest)
+	}
+	lastClosures[key] = c
+
+	if len(history) == maxHistory {
+		history = history[:maxHistory-1]
+	}
+	history = append([]PastSession{{Info: info, Close: c}}, history...)
+}
+
+func getLastClosure(key peerKey) *Closure {
+	historyLock.Lock()
+	defer historyLock.Unlock()
+	if c, found := lastClosures[key]; found {
+		return &c
+	}
+	return nil
+}
+
+// GetSessionHistory returns the most recently closed sessions, newest first
+func GetSessionHistory() []PastSession {
+	historyLock.Lock()
+	defer historyLock.Unlock()
+	result := make([]PastSession, len(history))
+	copy(result, history)
+	return result
+}
+
+func GetSessionHistoryJson() (string, error) {
+	result, err := json.Marshal(GetSessionHistory())
+	if err != nil {
+		return "", err
+	}
+	return string(result), nil
+}
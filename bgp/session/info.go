@@ -0,0 +1,89 @@
+package session
+
+import (
+	"FlapAlerted/bgp/common"
+	"net/netip"
+)
+
+// Info describes an established or past session
+type Info struct {
+	Remote        string
+	RemoteAddress netip.Addr `json:"-"`
+	BMPRouter     string
+	Description   string
+	RouterID      string
+	Hostname      string
+	ASN           uint32
+	EstablishTime int64
+	ImportCount   uint32
+	ImportLimit   uint32
+	// Negotiated capabilities
+	HoldTime         int
+	AddPath          bool
+	ExtendedMessages bool
+	ExtendedNextHop  bool
+	GracefulRestart  bool
+	// BGP Role of the remote speaker (RFC 9234)
+	Role *common.Role `json:",omitempty"`
+	// Closure of the previous session with the same peer
+	LastClose *Closure `json:",omitempty"`
+	// Handling of malformed UPDATE messages (RFC 7606)
+	UpdateErrors struct {
+		AttributeDiscard uint64
+		TreatAsWithdraw  uint64
+		AFIDisable       uint64
+	}
+	Stats Stats
+}
+
+// Stats are the counters of a session
+type Stats struct {
+	// Received messages by type
+	Messages struct {
+		Open         uint64
+		Update       uint64
+		Notification uint64
+		KeepAlive    uint64
+	}
+	ReceivedBytes uint64
+	AnnouncedNLRI uint64
+	WithdrawnNLRI uint64
+	PathChanges   uint64
+	LastError     string `json:",omitempty"`
+}
+
+func newInfo(s establishedSession) Info {
+	info := Info{
+		Remote:           s.Remote,
+		RemoteAddress:    s.remoteAddr,
+		BMPRouter:        s.BMPRouter,
+		Description:      s.Description,
+		RouterID:         s.session.RemoteRouterID.String(),
+		Hostname:         s.session.RemoteHostname,
+		ASN:              s.session.RemoteAsn,
+		EstablishTime:    s.EstablishTime,
+		ImportCount:      s.table.ImportCount(),
+		ImportLimit:      s.session.ImportLimit,
+		HoldTime:         s.session.ApplicableHoldTime,
+		AddPath:          s.session.AddPathEnabled,
+		ExtendedMessages: s.session.HasExtendedMessages,
+		ExtendedNextHop:  s.session.HasExtendedNextHopV4,
+		GracefulRestart:  s.session.HasGracefulRestart,
+		Role:             s.session.RemoteRole,
+	}
+	info.UpdateErrors.AttributeDiscard = s.session.UpdateErrors.AttributeDiscard.Load()
+	info.UpdateErrors.TreatAsWithdraw = s.session.UpdateErrors.TreatAsWithdraw.Load()
+	info.UpdateErrors.AFIDisable = s.session.UpdateErrors.AFIDisable.Load()
+
+	stats := &s.session.Stats
+	info.Stats.Messages.Open = stats.OpenMessages.Load()
+	info.Stats.Messages.Update = stats.UpdateMessages.Load()
+	info.Stats.Messages.Notification = stats.NotificationMessages.Load()
+	info.Stats.Messages.KeepAlive = stats.KeepAliveMessages.Load()
+	info.Stats.ReceivedBytes = stats.ReceivedBytes.Load()
+	info.Stats.AnnouncedNLRI = stats.AnnouncedNLRI.Load()
+	info.Stats.WithdrawnNLRI = stats.WithdrawnNLRI.Load()
+	info.Stats.PathChanges = s.table.PathChangeCount()
+	info.Stats.LastError = stats.LastError()
+	return info
+}
@@ -0,0 +1,50 @@
+package session
+
+import (
+	"FlapAlerted/bgp/table"
+	"cmp"
+	"net/netip"
+	"slices"
+)
+
+// Routes are the routes of a session matching a lookup
+type Routes struct {
+	Remote      string
+	BMPRouter   string
+	Description string
+	RouterID    string
+	ASN         uint32
+	Routes      []table.Route
+	// More routes matched than returned
+	Truncated bool
+}
+
+// LookupRoutes returns the routes matching the prefix in the tables of all established sessions, sorted by remote.
+// At most limit routes are returned per session.
+func LookupRoutes(prefix netip.Prefix, match table.MatchType, limit int) []Routes {
+	sessionTrackerLock.RLock()
+	sessions := make([]establishedSession, 0, len(sessionTracker))
+	for _, session := range sessionTracker {
+		sessions = append(sessions, session)
+	}
+	sessionTrackerLock.RUnlock()
+
+	slices.SortFunc(sessions, func(a, b establishedSession) int {
+		return cmp.Or(cmp.Compare(a.BMPRouter, b.BMPRouter), a.remoteAddr.Compare(b.remoteAddr), cmp.Compare(a.Remote, b.Remote))
+	})
+
+	result := make([]Routes, 0, len(sessions))
+	for _, session := range sessions {
+		routes, truncated := session.table.Lookup(prefix, match, limit)
+		result = append(result, Routes{
+			Remote:      session.Remote,
+			BMPRouter:   session.BMPRouter,
+			Description: session.Description,
+			RouterID:    session.session.RemoteRouterID.String(),
+			ASN:         session.session.RemoteAsn,
+			Routes:      routes,
+			Truncated:   truncated,
+		})
+	}
+	return result
+}
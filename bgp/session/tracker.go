@@ -3,41 +3,88 @@ package session
 import (
 	"FlapAlerted/bgp/common"
 	"FlapAlerted/bgp/table"
+	"context"
 	"encoding/json"
 	"net"
+	"net/netip"
 	"sync"
 	"time"
 )
 
 // Established session tracker
 var (
-	sessionTracker     = make(map[net.Conn]establishedSession)
+	sessionTracker     = make(map[*common.LocalSession]establishedSession)
 	sessionTrackerLock sync.RWMutex
 )
 
 type establishedSession struct {
 	Remote        string
+	BMPRouter     string // Empty for sessions established directly with FlapAlerted
+	Description   string
 	EstablishTime int64
+	remoteAddr    netip.Addr
 	session       *common.LocalSession
 	table         *table.PrefixTable
+	// Closes the session, nil for sessions monitored via BMP
+	cancel context.CancelCauseFunc
 }
 
-func AddSession(conn net.Conn, session *common.LocalSession, table *table.PrefixTable) {
-	newSession := establishedSession{
-		Remote:        conn.RemoteAddr().String(),
-		EstablishTime: time.Now().Unix(),
-		session:       session,
-		table:         table,
+// AddSession tracks a session established with FlapAlerted. The cancel function is used to close the session administratively.
+func AddSession(conn net.Conn, session *common.LocalSession, table *table.PrefixTable, cancel context.CancelCauseFunc) {
+	var remoteAddr netip.Addr
+	if tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		remoteAddr = tcpAddr.AddrPort().Addr().Unmap()
 	}
+	addSession(session, establishedSession{
+		Remote:     conn.RemoteAddr().String(),
+		remoteAddr: remoteAddr,
+		table:      table,
+		cancel:     cancel,
+	})
+}
+
+// AddMonitoredSession tracks a session of a router that is monitored via BMP
+func AddMonitoredSession(bmpRouter string, remote netip.Addr, session *common.LocalSession, table *table.PrefixTable) {
+	addSession(session, establishedSession{
+		Remote:     remote.String(),
+		BMPRouter:  bmpRouter,
+		remoteAddr: remote,
+		table:      table,
+	})
+}
+
+func addSession(session *common.LocalSession, newSession establishedSession) {
+	newSession.Description = session.Description
+	newSession.EstablishTime = time.Now().Unix()
+	newSession.session = session
 	sessionTrackerLock.Lock()
-	defer sessionTrackerLock.Unlock()
-	sessionTracker[conn] = newSession
+	sessionTracker[session] = newSession
+	sessionTrackerLock.Unlock()
+	info := newInfo(newSession)
+	info.LastClose = getLastClosure(peerKey{bmpRouter: newSession.BMPRouter, remoteAddr: newSession.remoteAddr})
+	sendEvent(newEvent(EventEstablished, info))
 }
 
-func RemoveSession(conn net.Conn) {
+// RemoveSession stops tracking a session and adds it to the session history.
+// A nil reason means that the session was closed without an error.
+func RemoveSession(session *common.LocalSession, reason error) {
 	sessionTrackerLock.Lock()
-	defer sessionTrackerLock.Unlock()
-	delete(sessionTracker, conn)
+	removed, found := sessionTracker[session]
+	delete(sessionTracker, session)
+	sessionTrackerLock.Unlock()
+	if found {
+		recordClosure(removed, reason)
+	}
+}
+
+// WarnImportLimit notifies modules that the session exceeded its import limit warning threshold
+func WarnImportLimit(session *common.LocalSession) {
+	sessionTrackerLock.RLock()
+	tracked, found := sessionTracker[session]
+	sessionTrackerLock.RUnlock()
+	if found {
+		sendEvent(newEvent(EventImportLimitWarning, newInfo(tracked)))
+	}
 }
 
 func GetSessionCount() int {
@@ -46,6 +93,19 @@ func GetSessionCount() int {
 	return len(sessionTracker)
 }
 
+// HasSessionFrom returns true if an established session with the given remote address exists.
+// Sessions monitored via BMP are not considered.
+func HasSessionFrom(addr netip.Addr) bool {
+	sessionTrackerLock.RLock()
+	defer sessionTrackerLock.RUnlock()
+	for _, session := range sessionTracker {
+		if session.BMPRouter == "" && session.remoteAddr == addr {
+			return true
+		}
+	}
+	return false
+}
+
 func GetTotalImportCount() uint32 {
 	sessionTrackerLock.RLock()
 	defer sessionTrackerLock.RUnlock()
@@ -56,28 +116,21 @@ func GetTotalImportCount() uint32 {
 	return totalCount
 }
 
-func GetSessionInfoJson() (string, error) {
+// GetSessions returns information about all established sessions
+func GetSessions() []Info {
 	sessionTrackerLock.RLock()
 	defer sessionTrackerLock.RUnlock()
-	type JSONInfo struct {
-		Remote        string
-		RouterID      string
-		Hostname      string
-		EstablishTime int64
-		ImportCount   uint32
-	}
-
-	var sessions = make([]JSONInfo, 0)
+	sessions := make([]Info, 0, len(sessionTracker))
 	for _, session := range sessionTracker {
-		sessions = append(sessions, JSONInfo{
-			Remote:        session.Remote,
-			RouterID:      session.session.RemoteRouterID.String(),
-			Hostname:      session.session.RemoteHostname,
-			EstablishTime: session.EstablishTime,
-			ImportCount:   session.table.ImportCount(),
-		})
+		info := newInfo(session)
+		info.LastClose = getLastClosure(peerKey{bmpRouter: session.BMPRouter, remoteAddr: session.remoteAddr})
+		sessions = append(sessions, info)
 	}
-	result, err := json.Marshal(sessions)
+	return sessions
+}
+
+func GetSessionInfoJson() (string, error) {
+	result, err := json.Marshal(GetSessions())
 	if err != nil {
 		return "", err
 	}
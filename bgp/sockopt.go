@@ -0,0 +1,95 @@
+package bgp
+
+import (
+	"FlapAlerted/config"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// listenConfig returns the configuration of the BGP listener.
+// TCP-MD5 keys of all neighbors are installed on the listener, as the key has to be known before the connection is accepted.
+func listenConfig() net.ListenConfig {
+	gtsm := config.GlobalConf.BgpMinTTL != 0
+	for _, n := range config.GlobalConf.Neighbors {
+		if n.MinTTL != nil && *n.MinTTL != 0 {
+			gtsm = true
+		}
+	}
+	return net.ListenConfig{Control: func(network, address string, c syscall.RawConn) error {
+		return control(c, func(fd int) error {
+			if gtsm {
+				// Accepted connections inherit the TTL of outgoing packets
+				if err := setMaxTTL(fd); err != nil {
+					return err
+				}
+			}
+			for _, n := range config.GlobalConf.Neighbors {
+				if n.Password == "" {
+					continue
+				}
+				if err := setMD5Key(fd, n.Address, n.Password); err != nil {
+					return fmt.Errorf("failed to set TCP-MD5 key of neighbor %s: %w", n.Address, err)
+				}
+			}
+			return nil
+		})
+	}}
+}
+
+// dialerControl returns a function that sets the TCP-MD5 key and TTL security options of the neighbor before connecting to it
+func dialerControl(neighbor config.Neighbor) func(network, address string, c syscall.RawConn) error {
+	minTTL := minTTLOf(neighbor, true)
+	return func(network, address string, c syscall.RawConn) error {
+		return control(c, func(fd int) error {
+			if neighbor.Password != "" {
+				if err := setMD5Key(fd, neighbor.Address, neighbor.Password); err != nil {
+					return fmt.Errorf("failed to set TCP-MD5 key: %w", err)
+				}
+			}
+			if minTTL != 0 {
+				if err := setMaxTTL(fd); err != nil {
+					return err
+				}
+				return setMinTTL(fd, minTTL)
+			}
+			return nil
+		})
+	}
+}
+
+// applyMinTTL enables the TTL security check on an accepted connection
+func applyMinTTL(conn net.Conn, minTTL int) error {
+	if minTTL == 0 {
+		return nil
+	}
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return fmt.Errorf("connection does not support socket options")
+	}
+	c, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	return control(c, func(fd int) error {
+		return setMinTTL(fd, minTTL)
+	})
+}
+
+// minTTLOf returns the minimum TTL of received packets of the session with the given neighbor
+func minTTLOf(neighbor config.Neighbor, isNeighbor bool) int {
+	if isNeighbor && neighbor.MinTTL != nil {
+		return *neighbor.MinTTL
+	}
+	return config.GlobalConf.BgpMinTTL
+}
+
+func control(c syscall.RawConn, f func(fd int) error) error {
+	var err error
+	if controlErr := c.Control(func(fd uintptr) {
+		err = f(int(fd))
+	}); controlErr != nil {
+		return controlErr
+	}
+	return err
+}
@@ -0,0 +1,110 @@
+//go:build linux
+
+package bgp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"syscall"
+)
+
+const (
+	tcpMD5SigExt        = 32 // TCP_MD5SIG_EXT, supports keys for address prefixes
+	tcpMD5SigFlagPrefix = 0x1
+	ipv6MinHopCount     = 73 // IPV6_MINHOPCOUNT
+	sockaddrStorageSize = 128
+)
+
+// setMD5Key installs the TCP-MD5 key (RFC 2385) for connections from and to the given addresses
+func setMD5Key(fd int, prefix netip.Prefix, key string) error {
+	family, err := socketFamily(fd)
+	if err != nil {
+		return err
+	}
+	addr := prefix.Addr()
+	if family == syscall.AF_INET6 && addr.Is4() {
+		// Dual-stack socket, IPv4 peers use mapped addresses while the prefix length remains that of IPv4
+		addr = netip.AddrFrom16(addr.As16())
+	} else if family == syscall.AF_INET && addr.Is6() {
+		// Neighbor cannot connect using this socket
+		return nil
+	}
+
+	// struct tcp_md5sig
+	sig := make([]byte, 0, sockaddrStorageSize+8+len(key))
+	sig = binary.NativeEndian.AppendUint16(sig, uint16(family))
+	sig = append(sig, 0, 0) // Port
+	if family == syscall.AF_INET6 {
+		sig = append(sig, 0, 0, 0, 0) // Flow info
+	}
+	sig = append(sig, addr.AsSlice()...)
+	sig = append(sig, make([]byte, sockaddrStorageSize-len(sig))...)
+
+	option := syscall.TCP_MD5SIG
+	var flags uint8
+	if !prefix.IsSingleIP() {
+		option = tcpMD5SigExt
+		flags = tcpMD5SigFlagPrefix
+	}
+	sig = append(sig, flags, uint8(prefix.Bits()))
+	sig = binary.NativeEndian.AppendUint16(sig, uint16(len(key)))
+	sig = binary.NativeEndian.AppendUint32(sig, 0) // Interface index
+	sig = append(sig, key...)
+	sig = append(sig, make([]byte, maxMD5KeyLength-len(key))...)
+
+	return syscall.SetsockoptString(fd, syscall.IPPROTO_TCP, option, string(sig))
+}
+
+// Size of the key field of struct tcp_md5sig
+const maxMD5KeyLength = 80
+
+// setMaxTTL sends packets with the maximum TTL, as expected by peers using TTL security (RFC 5082)
+func setMaxTTL(fd int) error {
+	family, err := socketFamily(fd)
+	if err != nil {
+		return err
+	}
+	if family == syscall.AF_INET6 {
+		if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, 255); err != nil {
+			return fmt.Errorf("failed to set IPv6 hop limit: %w", err)
+		}
+	}
+	// Also applies to IPv4 connections of dual-stack sockets
+	if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_IP, syscall.IP_TTL, 255); err != nil {
+		return fmt.Errorf("failed to set TTL: %w", err)
+	}
+	return nil
+}
+
+// setMinTTL discards received packets with a lower TTL (RFC 5082)
+func setMinTTL(fd int, minTTL int) error {
+	family, err := socketFamily(fd)
+	if err != nil {
+		return err
+	}
+	if family == syscall.AF_INET6 {
+		if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, ipv6MinHopCount, minTTL); err != nil {
+			return fmt.Errorf("failed to set minimum IPv6 hop count: %w", err)
+		}
+	}
+	if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_IP, syscall.IP_MINTTL, minTTL); err != nil {
+		return fmt.Errorf("failed to set minimum TTL: %w", err)
+	}
+	return nil
+}
+
+func socketFamily(fd int) (int, error) {
+	sa, err := syscall.Getsockname(fd)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get socket address: %w", err)
+	}
+	switch sa.(type) {
+	case *syscall.SockaddrInet4:
+		return syscall.AF_INET, nil
+	case *syscall.SockaddrInet6:
+		return syscall.AF_INET6, nil
+	default:
+		return 0, fmt.Errorf("unsupported socket address family")
+	}
+}
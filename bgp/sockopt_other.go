@@ -0,0 +1,22 @@
+//go:build !linux
+
+package bgp
+
+import (
+	"errors"
+	"net/netip"
+)
+
+var errSocketOptionUnsupported = errors.New("TCP-MD5 and TTL security are only supported on Linux")
+
+func setMD5Key(fd int, prefix netip.Prefix, key string) error {
+	return errSocketOptionUnsupported
+}
+
+func setMaxTTL(fd int) error {
+	return errSocketOptionUnsupported
+}
+
+func setMinTTL(fd int, minTTL int) error {
+	return errSocketOptionUnsupported
+}
@@ -2,63 +2,153 @@ package table
 
 import (
 	"FlapAlerted/bgp/common"
+	"FlapAlerted/bgp/update"
 	"context"
 	"fmt"
 	"log/slog"
+	"net/netip"
+	"time"
 )
 
+// Maximum time to wait for End-of-RIB markers after the session has been established
+const endOfRIBTimeout = 5 * time.Minute
+
 func ProcessUpdates(cancel context.CancelCauseFunc, updateChannel chan SessionUpdateMessage, table *PrefixTable) {
+	endOfRIBTimer := time.NewTimer(endOfRIBTimeout)
+	defer endOfRIBTimer.Stop()
+	if len(table.awaitingEndOfRIB) == 0 {
+		endOfRIBTimer.Stop()
+	}
+
 	for {
-		u, ok := <-updateChannel
-		if !ok {
-			return
+		var u SessionUpdateMessage
+		var ok bool
+		select {
+		case u, ok = <-updateChannel:
+			if !ok {
+				return
+			}
+		case <-endOfRIBTimer.C:
+			slog.Debug("End-of-RIB marker not received in time", "afi_count", len(table.awaitingEndOfRIB))
+			for afi := range table.awaitingEndOfRIB {
+				table.endOfRIB(afi)
+			}
+			continue
 		}
 
 		slog.Debug("Received update", "update", u)
 
-		nlRi, foundNlRi, err := u.GetMpReachNLRI()
-		if err != nil {
-			cancel(fmt.Errorf("error getting MpReachNLRI: %w", err))
+		if err := table.ProcessUpdate(u); err != nil {
+			cancel(err)
 			return
 		}
-		unReachNlRi, foundUnreachNlRi, err := u.GetMpUnReachNLRI()
-		if err != nil {
-			cancel(fmt.Errorf("error getting MpUnReachNLRI: %w", err))
-			return
+		if len(table.awaitingEndOfRIB) == 0 {
+			endOfRIBTimer.Stop()
 		}
+	}
+}
 
-		var asPath common.AsPath
-		// AS path is not included for withdrawals
-		if foundNlRi || len(u.Msg.NetworkLayerReachabilityInformation) != 0 {
-			var foundASPath bool
-			asPath, foundASPath, err = u.GetAsPath()
-			if err != nil {
-				cancel(fmt.Errorf("error getting ASPath: %w", err))
-				return
-			}
-			if !foundASPath {
-				cancel(fmt.Errorf("missing ASPath attribute"))
-				return
-			}
+// ProcessUpdate applies the routes of an update message to the table
+func (t *PrefixTable) ProcessUpdate(u SessionUpdateMessage) error {
+	if afi, isEndOfRIB := u.Msg.GetEndOfRIB(); isEndOfRIB {
+		slog.Debug("Received End-of-RIB marker", "afi", afi)
+		t.endOfRIB(afi)
+		return nil
+	}
+
+	treatAsWithdraw := false
+	for _, e := range u.Msg.Validate(u.Session) {
+		level := slog.LevelWarn
+		if e.Action == update.AttributeDiscard {
+			// Commonly caused by peers sending LOCAL_PREF on external sessions
+			level = slog.LevelDebug
 		}
+		slog.Log(context.Background(), level, "Received malformed UPDATE message", "remote", u.Session.RemoteAddress, "action", e.Action.String(), "attribute", e.Attribute, "error", e.Err)
+		u.Session.Stats.SetLastError(e)
+		switch e.Action {
+		case update.SessionReset:
+			return e
+		case update.AFIDisable:
+			u.Session.UpdateErrors.AFIDisable.Add(1)
+			t.disableAFI(e.AFI)
+		case update.TreatAsWithdraw:
+			u.Session.UpdateErrors.TreatAsWithdraw.Add(1)
+			treatAsWithdraw = true
+		case update.AttributeDiscard:
+			u.Session.UpdateErrors.AttributeDiscard.Add(1)
+		}
+	}
 
-		if foundNlRi {
-			for _, item := range nlRi.NLRI {
-				table.update(item.ToNetCidr(), item.PathID, false, asPath)
-			}
+	nlRi, foundNlRi, err := u.GetMpReachNLRI()
+	if err != nil {
+		return fmt.Errorf("error getting MpReachNLRI: %w", err)
+	}
+	unReachNlRi, foundUnreachNlRi, err := u.GetMpUnReachNLRI()
+	if err != nil {
+		return fmt.Errorf("error getting MpUnReachNLRI: %w", err)
+	}
+	u.Session.Stats.AnnouncedNLRI.Add(uint64(len(nlRi.NLRI) + len(u.Msg.NetworkLayerReachabilityInformation)))
+	u.Session.Stats.WithdrawnNLRI.Add(uint64(len(unReachNlRi.Withdrawn) + len(u.Msg.WithdrawnRoutesList)))
+
+	var asPath common.AsPath
+	var attributes *common.PathAttributes
+	possibleLeak := false
+	// AS path is not included for withdrawals
+	if !treatAsWithdraw && (foundNlRi || len(u.Msg.NetworkLayerReachabilityInformation) != 0) {
+		var foundASPath bool
+		asPath, foundASPath, err = u.GetAsPath()
+		if err != nil {
+			return fmt.Errorf("error getting ASPath: %w", err)
 		}
+		if !foundASPath {
+			return fmt.Errorf("missing ASPath attribute")
+		}
+		// Shared by all paths with the same AS path across sessions
+		asPath = common.InternAsPath(asPath)
+		attributes, err = u.GetPathAttributes()
+		if err != nil {
+			return fmt.Errorf("error getting path attributes: %w", err)
+		}
+		possibleLeak = u.Session.IsPossibleRouteLeak(attributes)
+	}
+
+	if foundNlRi && !t.isDisabled(nlRi.AFI) {
+		var mpAttributes *common.PathAttributes
+		if !treatAsWithdraw {
+			mpAttributes = mpReachAttributes(attributes, nlRi)
+		}
+		for _, item := range nlRi.NLRI {
+			t.update(item.ToNetCidr(), item.PathID, treatAsWithdraw, asPath, mpAttributes, possibleLeak)
+		}
+	}
+	if !t.isDisabled(u.Session.DefaultAFI) {
 		for _, item := range u.Msg.NetworkLayerReachabilityInformation {
-			table.update(item.ToNetCidr(), item.PathID, false, asPath)
+			t.update(item.ToNetCidr(), item.PathID, treatAsWithdraw, asPath, attributes, possibleLeak)
 		}
+	}
 
-		if foundUnreachNlRi {
-			for _, item := range unReachNlRi.Withdrawn {
-				table.update(item.ToNetCidr(), item.PathID, true, nil)
-			}
+	if foundUnreachNlRi && !t.isDisabled(unReachNlRi.AFI) {
+		for _, item := range unReachNlRi.Withdrawn {
+			t.update(item.ToNetCidr(), item.PathID, true, nil, nil, false)
 		}
+	}
+	if !t.isDisabled(u.Session.DefaultAFI) {
 		for _, item := range u.Msg.WithdrawnRoutesList {
-			table.update(item.ToNetCidr(), item.PathID, true, nil)
+			t.update(item.ToNetCidr(), item.PathID, true, nil, nil, false)
 		}
+	}
+	return nil
+}
 
+// mpReachAttributes returns the attributes of routes announced in the MP_REACH_NLRI attribute, which carries its own next hop
+func mpReachAttributes(attributes *common.PathAttributes, nlRi update.MPReachNLRI) *common.PathAttributes {
+	mpAttributes := *attributes
+	mpAttributes.NextHop = netip.Addr{}
+	if len(nlRi.NextHop) != 0 {
+		mpAttributes.NextHop = nlRi.NextHop[0]
+	}
+	if len(nlRi.NextHop) > 1 {
+		mpAttributes.LinkLocalNextHop = nlRi.NextHop[1]
 	}
+	return &mpAttributes
 }
@@ -0,0 +1,116 @@
+package table
+
+import (
+	"FlapAlerted/bgp/common"
+	"cmp"
+	"fmt"
+	"iter"
+	"net/netip"
+	"slices"
+)
+
+// MatchType selects the prefixes returned by a table lookup
+type MatchType uint8
+
+const (
+	// MatchExact returns the queried prefix only
+	MatchExact MatchType = iota
+	// MatchLongest returns the most specific prefix covering the queried prefix
+	MatchLongest
+	// MatchMoreSpecific returns the queried prefix and all prefixes covered by it
+	MatchMoreSpecific
+	// MatchLessSpecific returns the queried prefix and all prefixes covering it
+	MatchLessSpecific
+)
+
+func (m MatchType) String() string {
+	switch m {
+	case MatchExact:
+		return "exact"
+	case MatchLongest:
+		return "longest"
+	case MatchMoreSpecific:
+		return "more-specific"
+	case MatchLessSpecific:
+		return "less-specific"
+	}
+	return fmt.Sprintf("unknown (%d)", uint8(m))
+}
+
+func (m MatchType) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func ParseMatchType(s string) (MatchType, error) {
+	for m := MatchExact; m <= MatchLessSpecific; m++ {
+		if m.String() == s {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown match type %q", s)
+}
+
+// Route is a path of a prefix in the table
+type Route struct {
+	Prefix     netip.Prefix
+	PathID     uint32
+	AsPath     common.AsPath
+	Attributes *common.PathAttributes
+	// Retained from a previous session while the peer restarts gracefully
+	Stale             bool
+	PossibleRouteLeak bool
+}
+
+// Lookup returns the routes of the prefixes matching the masked prefix, sorted by prefix and path ID.
+// At most limit routes are returned, truncated is set if more routes matched.
+func (t *PrefixTable) Lookup(prefix netip.Prefix, match MatchType, limit int) (routes []Route, truncated bool) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	var prefixes iter.Seq2[netip.Prefix, *Entry]
+	switch match {
+	case MatchExact:
+		prefixes = func(yield func(netip.Prefix, *Entry) bool) {
+			if entry, found := t.table.Get(prefix); found {
+				yield(prefix, entry)
+			}
+		}
+	case MatchLongest:
+		prefixes = func(yield func(netip.Prefix, *Entry) bool) {
+			if p, entry, found := t.table.LongestMatch(prefix); found {
+				yield(p, entry)
+			}
+		}
+	case MatchMoreSpecific:
+		prefixes = t.table.MoreSpecifics(prefix)
+	case MatchLessSpecific:
+		prefixes = t.table.Covering(prefix)
+	default:
+		return nil, false
+	}
+
+	routes = make([]Route, 0)
+	for p, entry := range prefixes {
+		if len(routes) >= limit {
+			return routes[:limit], true
+		}
+		start := len(routes)
+		for pathID, path := range entry.Paths {
+			routes = append(routes, Route{
+				Prefix:            p,
+				PathID:            pathID,
+				AsPath:            path.AsPath,
+				Attributes:        path.Attributes,
+				Stale:             path.stale,
+				PossibleRouteLeak: path.possibleLeak,
+			})
+		}
+		slices.SortFunc(routes[start:], func(a, b Route) int {
+			return cmp.Compare(a.PathID, b.PathID)
+		})
+	}
+	if len(routes) > limit {
+		return routes[:limit], true
+	}
+	return routes, false
+}
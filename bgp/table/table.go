@@ -3,73 +3,329 @@ package table
 import (
 	"FlapAlerted/bgp/common"
 	"FlapAlerted/bgp/notification"
-	"FlapAlerted/config"
+	"FlapAlerted/bgp/trie"
 	"context"
 	"net/netip"
+	"slices"
+	"sync"
 	"sync/atomic"
 )
 
 type PrefixTable struct {
-	table               map[netip.Prefix]*Entry
-	pathChangeChan      chan PathChange
+	// Guards table, which is read by lookups while the session updates it
+	lock           sync.RWMutex
+	table          trie.Trie[*Entry]
+	pathChangeChan chan PathChange
+	// Session of the path changes emitted by the table
+	sessionID           common.SessionID
 	importCount         atomic.Uint32
+	importLimit         uint32
 	sessionCancellation context.CancelCauseFunc
+
+	// Called once per session when the import count exceeds the warning threshold
+	importWarning          func()
+	importWarningThreshold uint32
+	importWarned           bool
+
+	// Address families for which an End-of-RIB marker is awaited.
+	// Updates received before it are part of the initial table dump.
+	awaitingEndOfRIB map[common.AFI]struct{}
+	staleCount       int
+
+	// Address families that are no longer processed after receiving a malformed MP_(UN)REACH_NLRI attribute (RFC 7606)
+	disabledAFIs map[common.AFI]struct{}
+
+	// Path changes emitted since the table is used by the current session
+	pathChangeCount atomic.Uint64
 }
 
-func NewPrefixTable(pathChangeChan chan PathChange, sessionCancellation context.CancelCauseFunc) *PrefixTable {
-	return &PrefixTable{table: make(map[netip.Prefix]*Entry), pathChangeChan: pathChangeChan, sessionCancellation: sessionCancellation}
+func NewPrefixTable(pathChangeChan chan PathChange, sessionCancellation context.CancelCauseFunc, session *common.LocalSession) *PrefixTable {
+	return &PrefixTable{
+		pathChangeChan:      pathChangeChan,
+		sessionID:           session.ID(),
+		sessionCancellation: sessionCancellation,
+		importLimit:         session.ImportLimit,
+		awaitingEndOfRIB:    make(map[common.AFI]struct{}),
+		disabledAFIs:        make(map[common.AFI]struct{}),
+	}
 }
 
 type PathChange struct {
-	Prefix       netip.Prefix
+	Prefix netip.Prefix
+	// Session the change was received on
+	Session      common.SessionID
 	IsWithdrawal bool
 	OldPath      common.AsPath
+	// AS path of the announced path, nil for withdrawals
+	NewPath common.AsPath
+	// Attributes other than the AS path of the replaced or withdrawn path
+	OldAttributes *common.PathAttributes
+	// The AS path remained the same, only other attributes such as the next hop, MED or communities changed
+	IsAttributeChange bool
+	// The change was received as part of the initial table dump of a session
+	IsInitialDump bool
+	// The old or the new path carries an Only to Customer attribute although it was received
+	// from a customer or a peer (RFC 9234)
+	IsPossibleRouteLeak bool
+	// Marks the loss of the session, whose paths have been discarded without being withdrawn. Only Session is set.
+	IsSessionReset bool
 }
 
 type Entry struct {
-	Paths map[uint32]common.AsPath
+	Paths map[uint32]Path
 }
 
-func (t *PrefixTable) update(prefix netip.Prefix, pathID uint32, isWithdrawal bool, asPath common.AsPath) {
+type Path struct {
+	AsPath     common.AsPath
+	Attributes *common.PathAttributes
+	// Classified as a possible route leak when received
+	possibleLeak bool
+	// Retained from a previous session while the peer restarts gracefully
+	stale bool
+}
+
+func (t *PrefixTable) update(prefix netip.Prefix, pathID uint32, isWithdrawal bool, asPath common.AsPath, attributes *common.PathAttributes, possibleLeak bool) {
+	t.lock.Lock()
+	change, changed := t.apply(prefix, pathID, isWithdrawal, asPath, attributes, possibleLeak)
+	t.lock.Unlock()
+	if changed {
+		t.emit(change)
+	}
 	if isWithdrawal {
-		if entry, ok := t.table[prefix]; ok {
+		return
+	}
+	if count := t.importCount.Load(); t.importLimit != 0 && count > t.importLimit {
+		t.sessionCancellation(notification.ErrImportLimit)
+	} else if t.importWarning != nil && !t.importWarned && count > t.importWarningThreshold {
+		t.importWarned = true
+		t.importWarning()
+	}
+}
+
+// apply updates the path of the prefix and returns the resulting path change, if any. The table must be locked.
+func (t *PrefixTable) apply(prefix netip.Prefix, pathID uint32, isWithdrawal bool, asPath common.AsPath, attributes *common.PathAttributes, possibleLeak bool) (PathChange, bool) {
+	var change PathChange
+	changed := false
+	if isWithdrawal {
+		if entry, ok := t.table.Get(prefix); ok {
 			if oldPath, exists := entry.Paths[pathID]; exists {
-				t.pathChangeChan <- PathChange{
-					Prefix:       prefix,
-					IsWithdrawal: true,
-					OldPath:      oldPath,
-				}
-				t.importCount.Add(^uint32(0))
-				delete(entry.Paths, pathID)
-				if len(entry.Paths) == 0 {
-					delete(t.table, prefix)
+				change = PathChange{
+					Prefix:              prefix,
+					IsWithdrawal:        true,
+					OldPath:             oldPath.AsPath,
+					OldAttributes:       oldPath.Attributes,
+					IsInitialDump:       t.isInitialDump(prefix),
+					IsPossibleRouteLeak: oldPath.possibleLeak,
 				}
+				changed = true
+				t.removePath(prefix, entry, pathID)
 			}
 		}
+		return change, changed
+	}
+
+	entry, found := t.table.Get(prefix)
+	if !found {
+		t.importCount.Add(1)
+		entry = &Entry{Paths: make(map[uint32]Path)}
+		t.table.Insert(prefix, entry)
 	} else {
-		entry, found := t.table[prefix]
-		if !found {
-			t.importCount.Add(1)
-			entry = &Entry{Paths: make(map[uint32]common.AsPath)}
-			t.table[prefix] = entry
-		} else {
-			if oldPath, existed := entry.Paths[pathID]; existed {
-				t.pathChangeChan <- PathChange{
-					Prefix:       prefix,
-					IsWithdrawal: false,
-					OldPath:      oldPath,
+		if oldPath, existed := entry.Paths[pathID]; existed {
+			if oldPath.stale {
+				t.staleCount--
+			}
+			samePath := slices.Equal(oldPath.AsPath, asPath)
+			// A stale path that is announced again unchanged is not a path change
+			if !oldPath.stale || !samePath || !oldPath.Attributes.Equal(attributes) {
+				change = PathChange{
+					Prefix:              prefix,
+					IsWithdrawal:        false,
+					OldPath:             oldPath.AsPath,
+					NewPath:             asPath,
+					OldAttributes:       oldPath.Attributes,
+					IsAttributeChange:   samePath && !oldPath.Attributes.Equal(attributes),
+					IsInitialDump:       t.isInitialDump(prefix),
+					IsPossibleRouteLeak: oldPath.possibleLeak || possibleLeak,
 				}
-			} else {
-				t.importCount.Add(1)
+				changed = true
 			}
+		} else {
+			t.importCount.Add(1)
 		}
-		entry.Paths[pathID] = asPath
-		if t.importCount.Load() > config.GlobalConf.ImportLimit {
-			t.sessionCancellation(notification.ErrImportLimit)
-		}
 	}
+	entry.Paths[pathID] = Path{AsPath: asPath, Attributes: attributes, possibleLeak: possibleLeak}
+	return change, changed
+}
+
+func (t *PrefixTable) emit(change PathChange) {
+	change.Session = t.sessionID
+	t.pathChangeCount.Add(1)
+	t.pathChangeChan <- change
+}
+
+// Discard reports the loss of the session of the table with a session reset marker, as its paths are discarded
+// without being withdrawn. Must not be called while the table is in use by a session.
+func (t *PrefixTable) Discard() {
+	t.pathChangeChan <- PathChange{Session: t.sessionID, IsSessionReset: true}
+}
+
+// removePath removes a path from the table, which must be locked
+func (t *PrefixTable) removePath(prefix netip.Prefix, entry *Entry, pathID uint32) {
+	if entry.Paths[pathID].stale {
+		t.staleCount--
+	}
+	t.importCount.Add(^uint32(0))
+	delete(entry.Paths, pathID)
+	if len(entry.Paths) == 0 {
+		t.table.Delete(prefix)
+	}
+}
+
+// SetImportWarning sets the function called when the import count exceeds the threshold for the first time in the current session.
+// A zero threshold disables the warning. Must be called before the table is used.
+func (t *PrefixTable) SetImportWarning(threshold uint32, warn func()) {
+	t.importWarningThreshold = threshold
+	t.importWarning = nil
+	if threshold != 0 {
+		t.importWarning = warn
+	}
+	t.importWarned = false
 }
 
 func (t *PrefixTable) ImportCount() uint32 {
 	return t.importCount.Load()
 }
+
+// PathChangeCount returns the number of path changes emitted since the table is used by the current session
+func (t *PrefixTable) PathChangeCount() uint64 {
+	return t.pathChangeCount.Load()
+}
+
+func afiOf(prefix netip.Prefix) common.AFI {
+	if prefix.Addr().Is4() {
+		return common.AFI4
+	}
+	return common.AFI6
+}
+
+func (t *PrefixTable) isInitialDump(prefix netip.Prefix) bool {
+	if len(t.awaitingEndOfRIB) == 0 {
+		return false
+	}
+	_, awaiting := t.awaitingEndOfRIB[afiOf(prefix)]
+	return awaiting
+}
+
+// ExpectEndOfRIB marks updates for the given address families as part of the initial table dump
+// until an End-of-RIB marker is received for them
+func (t *PrefixTable) ExpectEndOfRIB(afis []common.AFI) {
+	for _, afi := range afis {
+		t.awaitingEndOfRIB[afi] = struct{}{}
+	}
+}
+
+func (t *PrefixTable) endOfRIB(afi common.AFI) {
+	delete(t.awaitingEndOfRIB, afi)
+	// Stale paths that have not been announced again by the restarted peer are withdrawn
+	t.removeStalePaths(func(a common.AFI) bool { return a == afi })
+}
+
+func (t *PrefixTable) removeStalePaths(match func(common.AFI) bool) {
+	if t.staleCount == 0 {
+		return
+	}
+	var withdrawn []PathChange
+	t.lock.Lock()
+	for prefix, entry := range t.table.All() {
+		if !match(afiOf(prefix)) {
+			continue
+		}
+		for pathID, path := range entry.Paths {
+			if !path.stale {
+				continue
+			}
+			withdrawn = append(withdrawn, withdrawalOf(prefix, path))
+			t.removePath(prefix, entry, pathID)
+		}
+	}
+	t.lock.Unlock()
+	for _, change := range withdrawn {
+		t.emit(change)
+	}
+}
+
+func withdrawalOf(prefix netip.Prefix, path Path) PathChange {
+	return PathChange{
+		Prefix:              prefix,
+		IsWithdrawal:        true,
+		OldPath:             path.AsPath,
+		OldAttributes:       path.Attributes,
+		IsPossibleRouteLeak: path.possibleLeak,
+	}
+}
+
+// MarkStale retains the paths of the given address families as stale after the session of the table was lost
+// and the peer is expected to restart gracefully. Paths of all other address families are withdrawn.
+// Must not be called while the table is in use by a session.
+func (t *PrefixTable) MarkStale(retainedAFIs []common.AFI) {
+	var withdrawn []PathChange
+	t.lock.Lock()
+	defer func() {
+		t.lock.Unlock()
+		for _, change := range withdrawn {
+			t.emit(change)
+		}
+	}()
+	for prefix, entry := range t.table.All() {
+		retained := slices.Contains(retainedAFIs, afiOf(prefix))
+		for pathID, path := range entry.Paths {
+			if !retained {
+				withdrawn = append(withdrawn, withdrawalOf(prefix, path))
+				t.removePath(prefix, entry, pathID)
+				continue
+			}
+			if !path.stale {
+				path.stale = true
+				entry.Paths[pathID] = path
+				t.staleCount++
+			}
+		}
+	}
+	clear(t.awaitingEndOfRIB)
+	clear(t.disabledAFIs)
+}
+
+// Resume prepares a table with stale paths for use by the new session of the restarted peer.
+// Stale paths of address families for which the peer has not preserved its forwarding state are withdrawn.
+func (t *PrefixTable) Resume(sessionCancellation context.CancelCauseFunc, session *common.LocalSession) {
+	t.sessionCancellation = sessionCancellation
+	t.sessionID = session.ID()
+	t.importLimit = session.ImportLimit
+	t.pathChangeCount.Store(0)
+	t.removeStalePaths(func(afi common.AFI) bool { return !slices.Contains(session.ForwardingStateAFIs, afi) })
+}
+
+// disableAFI removes all paths of the address family and ignores further routes of it for the remainder of the session.
+// The removed paths are not reported as path changes, as they are not caused by prefix instability.
+func (t *PrefixTable) disableAFI(afi common.AFI) {
+	if t.isDisabled(afi) {
+		return
+	}
+	t.disabledAFIs[afi] = struct{}{}
+	delete(t.awaitingEndOfRIB, afi)
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for prefix, entry := range t.table.All() {
+		if afiOf(prefix) != afi {
+			continue
+		}
+		for pathID := range entry.Paths {
+			t.removePath(prefix, entry, pathID)
+		}
+	}
+}
+
+func (t *PrefixTable) isDisabled(afi common.AFI) bool {
+	_, disabled := t.disabledAFIs[afi]
+	return disabled
+}
@@ -65,3 +65,7 @@ func (u *SessionUpdateMessage) GetMpUnReachNLRI() (update.MPUnReachNLRI, bool, e
 func (u *SessionUpdateMessage) GetAsPath() (common.AsPath, bool, error) {
 	return u.Msg.GetAsPath(u.Session)
 }
+
+func (u *SessionUpdateMessage) GetPathAttributes() (*common.PathAttributes, error) {
+	return u.Msg.GetPathAttributes(u.Session)
+}
@@ -0,0 +1,254 @@
+// Package trie implements a path-compressed binary trie of IPv4 and IPv6 prefixes
+package trie
+
+import (
+	"iter"
+	"math/bits"
+	"net/netip"
+)
+
+// Trie maps prefixes to values. Prefixes are masked before use.
+// The zero value is an empty trie ready to use. A Trie must not be used concurrently without synchronization.
+type Trie[V any] struct {
+	root4 *node[V]
+	root6 *node[V]
+	size  int
+}
+
+type node[V any] struct {
+	prefix netip.Prefix
+	// Child prefixes with the next bit after this prefix unset and set
+	children [2]*node[V]
+	// Nodes without a value only join their children
+	value    V
+	hasValue bool
+}
+
+func (t *Trie[V]) root(p netip.Prefix) **node[V] {
+	if p.Addr().Is4() {
+		return &t.root4
+	}
+	return &t.root6
+}
+
+// Len returns the number of prefixes in the trie
+func (t *Trie[V]) Len() int {
+	return t.size
+}
+
+// Get returns the value of the prefix
+func (t *Trie[V]) Get(p netip.Prefix) (V, bool) {
+	p = p.Masked()
+	for n := *t.root(p); n != nil; {
+		if n.prefix.Bits() > p.Bits() || !n.prefix.Contains(p.Addr()) {
+			break
+		}
+		if n.prefix.Bits() == p.Bits() {
+			return n.value, n.hasValue
+		}
+		n = n.children[bitAt(p.Addr(), n.prefix.Bits())]
+	}
+	var zero V
+	return zero, false
+}
+
+// Insert sets the value of the prefix, replacing any previous value
+func (t *Trie[V]) Insert(p netip.Prefix, value V) {
+	p = p.Masked()
+	link := t.root(p)
+	for {
+		n := *link
+		if n == nil {
+			*link = &node[V]{prefix: p, value: value, hasValue: true}
+			t.size++
+			return
+		}
+		common := min(commonBits(n.prefix.Addr(), p.Addr()), n.prefix.Bits(), p.Bits())
+		if common == n.prefix.Bits() && common == p.Bits() {
+			if !n.hasValue {
+				t.size++
+			}
+			n.value = value
+			n.hasValue = true
+			return
+		}
+		if common == n.prefix.Bits() {
+			// The prefix is more specific than the node
+			link = &n.children[bitAt(p.Addr(), common)]
+			continue
+		}
+
+		inserted := &node[V]{prefix: p, value: value, hasValue: true}
+		if common == p.Bits() {
+			// The prefix covers the node
+			inserted.children[bitAt(n.prefix.Addr(), common)] = n
+			*link = inserted
+		} else {
+			// Both diverge after a shorter common prefix
+			branch := &node[V]{prefix: netip.PrefixFrom(p.Addr(), common).Masked()}
+			branch.children[bitAt(n.prefix.Addr(), common)] = n
+			branch.children[bitAt(p.Addr(), common)] = inserted
+			*link = branch
+		}
+		t.size++
+		return
+	}
+}
+
+// Delete removes the prefix. Returns false if the prefix was not present.
+func (t *Trie[V]) Delete(p netip.Prefix) bool {
+	p = p.Masked()
+	var parentLink **node[V]
+	link := t.root(p)
+	for {
+		n := *link
+		if n == nil || n.prefix.Bits() > p.Bits() || !n.prefix.Contains(p.Addr()) {
+			return false
+		}
+		if n.prefix.Bits() < p.Bits() {
+			parentLink = link
+			link = &n.children[bitAt(p.Addr(), n.prefix.Bits())]
+			continue
+		}
+		if !n.hasValue {
+			return false
+		}
+		var zero V
+		n.value = zero
+		n.hasValue = false
+		t.size--
+
+		switch {
+		case n.children[0] != nil && n.children[1] != nil:
+			// Still needed to join both children
+		case n.children[0] != nil:
+			*link = n.children[0]
+		case n.children[1] != nil:
+			*link = n.children[1]
+		default:
+			*link = nil
+			// The parent may now only join a single child
+			if parentLink != nil {
+				parent := *parentLink
+				if !parent.hasValue {
+					if parent.children[0] != nil {
+						*parentLink = parent.children[0]
+					} else {
+						*parentLink = parent.children[1]
+					}
+				}
+			}
+		}
+		return true
+	}
+}
+
+// All returns all prefixes and their values, IPv4 before IPv6, sorted by address and then by prefix length.
+// The prefix last returned may be deleted during the iteration.
+func (t *Trie[V]) All() iter.Seq2[netip.Prefix, V] {
+	return func(yield func(netip.Prefix, V) bool) {
+		if walk(t.root4, yield) {
+			walk(t.root6, yield)
+		}
+	}
+}
+
+// MoreSpecifics returns the prefix and all prefixes covered by it, sorted like All
+func (t *Trie[V]) MoreSpecifics(p netip.Prefix) iter.Seq2[netip.Prefix, V] {
+	p = p.Masked()
+	return func(yield func(netip.Prefix, V) bool) {
+		n := *t.root(p)
+		// Descend to the first node covered by the prefix
+		for n != nil && n.prefix.Bits() < p.Bits() {
+			if !n.prefix.Contains(p.Addr()) {
+				return
+			}
+			n = n.children[bitAt(p.Addr(), n.prefix.Bits())]
+		}
+		if n == nil || !p.Contains(n.prefix.Addr()) {
+			return
+		}
+		walk(n, yield)
+	}
+}
+
+// Covering returns all prefixes covering the prefix, including the prefix itself, from the least to the most specific
+func (t *Trie[V]) Covering(p netip.Prefix) iter.Seq2[netip.Prefix, V] {
+	p = p.Masked()
+	return func(yield func(netip.Prefix, V) bool) {
+		for n := *t.root(p); n != nil; {
+			if n.prefix.Bits() > p.Bits() || !n.prefix.Contains(p.Addr()) {
+				return
+			}
+			if n.hasValue && !yield(n.prefix, n.value) {
+				return
+			}
+			if n.prefix.Bits() == p.Bits() {
+				return
+			}
+			n = n.children[bitAt(p.Addr(), n.prefix.Bits())]
+		}
+	}
+}
+
+// LongestMatch returns the most specific prefix covering the prefix, which may be the prefix itself
+func (t *Trie[V]) LongestMatch(p netip.Prefix) (netip.Prefix, V, bool) {
+	var match netip.Prefix
+	var value V
+	found := false
+	for prefix, v := range t.Covering(p) {
+		match, value, found = prefix, v, true
+	}
+	return match, value, found
+}
+
+// walk visits the prefixes below the node in order. Returns false if the iteration was stopped.
+func walk[V any](root *node[V], yield func(netip.Prefix, V) bool) bool {
+	if root == nil {
+		return true
+	}
+	stack := []*node[V]{root}
+	for len(stack) != 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		// Children are retrieved first, so that the node can be deleted by the caller
+		for i := 1; i >= 0; i-- {
+			if n.children[i] != nil {
+				stack = append(stack, n.children[i])
+			}
+		}
+		if n.hasValue && !yield(n.prefix, n.value) {
+			return false
+		}
+	}
+	return true
+}
+
+// bitAt returns the bit of the address at the given position, counted from the most significant bit
+func bitAt(a netip.Addr, i int) int {
+	if a.Is4() {
+		b := a.As4()
+		return int(b[i/8]>>(7-i%8)) & 1
+	}
+	b := a.As16()
+	return int(b[i/8]>>(7-i%8)) & 1
+}
+
+// commonBits returns the number of leading bits both addresses of the same family have in common
+func commonBits(a, b netip.Addr) int {
+	if a.Is4() {
+		x, y := a.As4(), b.As4()
+		return commonBitsOf(x[:], y[:])
+	}
+	x, y := a.As16(), b.As16()
+	return commonBitsOf(x[:], y[:])
+}
+
+func commonBitsOf(a, b []byte) int {
+	for i := range a {
+		if x := a[i] ^ b[i]; x != 0 {
+			return i*8 + bits.LeadingZeros8(x)
+		}
+	}
+	return len(a) * 8
+}
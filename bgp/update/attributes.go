@@ -0,0 +1,178 @@
+package update
+
+import (
+	"FlapAlerted/bgp/common"
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"slices"
+)
+
+func parseNextHopAttribute(a pathAttribute) (pathAttributeBody, error) {
+	if len(a.Body) != 4 {
+		return nil, fmt.Errorf("invalid length %d", len(a.Body))
+	}
+	return nextHopAttribute{NextHop: netip.AddrFrom4([4]byte(a.Body))}, nil
+}
+
+func parseUint32Attribute(a pathAttribute) (uint32, error) {
+	if len(a.Body) != 4 {
+		return 0, fmt.Errorf("invalid length %d", len(a.Body))
+	}
+	return binary.BigEndian.Uint32(a.Body), nil
+}
+
+func parseAtomicAggregateAttribute(a pathAttribute) (pathAttributeBody, error) {
+	if len(a.Body) != 0 {
+		return nil, fmt.Errorf("invalid length %d", len(a.Body))
+	}
+	return atomicAggregateAttribute{}, nil
+}
+
+func parseAggregatorAttribute(a pathAttribute, twoByteAsn bool) (pathAttributeBody, error) {
+	result := aggregatorAttribute{}
+	switch {
+	case twoByteAsn && len(a.Body) == 6:
+		result.Asn = uint32(binary.BigEndian.Uint16(a.Body))
+		result.Address = netip.AddrFrom4([4]byte(a.Body[2:]))
+	case !twoByteAsn && len(a.Body) == 8:
+		result.Asn = binary.BigEndian.Uint32(a.Body)
+		result.Address = netip.AddrFrom4([4]byte(a.Body[4:]))
+	default:
+		return nil, fmt.Errorf("invalid length %d", len(a.Body))
+	}
+	return result, nil
+}
+
+func parseCommunitiesAttribute(a pathAttribute) (pathAttributeBody, error) {
+	if len(a.Body)%4 != 0 {
+		return nil, fmt.Errorf("invalid length %d", len(a.Body))
+	}
+	result := communitiesAttribute{Communities: make([]uint32, 0, len(a.Body)/4)}
+	for i := 0; i < len(a.Body); i += 4 {
+		result.Communities = append(result.Communities, binary.BigEndian.Uint32(a.Body[i:]))
+	}
+	return result, nil
+}
+
+func parseExtendedCommunitiesAttribute(a pathAttribute) (pathAttributeBody, error) {
+	if len(a.Body)%8 != 0 {
+		return nil, fmt.Errorf("invalid length %d", len(a.Body))
+	}
+	result := extendedCommunitiesAttribute{Communities: make([]uint64, 0, len(a.Body)/8)}
+	for i := 0; i < len(a.Body); i += 8 {
+		result.Communities = append(result.Communities, binary.BigEndian.Uint64(a.Body[i:]))
+	}
+	return result, nil
+}
+
+func parseLargeCommunitiesAttribute(a pathAttribute) (pathAttributeBody, error) {
+	if len(a.Body)%12 != 0 {
+		return nil, fmt.Errorf("invalid length %d", len(a.Body))
+	}
+	result := largeCommunitiesAttribute{Communities: make([]common.LargeCommunity, 0, len(a.Body)/12)}
+	for i := 0; i < len(a.Body); i += 12 {
+		result.Communities = append(result.Communities, common.LargeCommunity{
+			GlobalAdministrator: binary.BigEndian.Uint32(a.Body[i:]),
+			LocalData1:          binary.BigEndian.Uint32(a.Body[i+4:]),
+			LocalData2:          binary.BigEndian.Uint32(a.Body[i+8:]),
+		})
+	}
+	return result, nil
+}
+
+// GetPathAttributes returns the attributes of the announced paths other than the AS path.
+// The next hop is taken from the NEXT_HOP attribute, which applies to routes outside the MP_REACH_NLRI attribute.
+func (u Msg) GetPathAttributes(session *common.LocalSession) (*common.PathAttributes, error) {
+	result := &common.PathAttributes{}
+	var as4Aggregator *common.Aggregator
+	for _, a := range u.PathAttributes {
+		switch a.TypeCode {
+		case OriginAttr, NextHopAttr, MultiExitDiscAttr, LocalPrefAttr, AtomicAggregateAttr, AggregatorAttr,
+			CommunitiesAttr, ExtendedCommunitiesAttr, LargeCommunitiesAttr, As4AggregatorAttr, OnlyToCustomerAttr:
+		default:
+			continue
+		}
+		attribute, err := a.GetAttribute(session)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing attribute %d: %w", a.TypeCode, err)
+		}
+		switch v := attribute.(type) {
+		case originAttribute:
+			result.Origin = common.Origin(v.Origin)
+		case nextHopAttribute:
+			result.NextHop = v.NextHop
+		case multiExitDiscAttribute:
+			result.MultiExitDisc = v.Value
+			result.HasMultiExitDisc = true
+		case localPrefAttribute:
+			result.LocalPref = v.Value
+			result.HasLocalPref = true
+		case atomicAggregateAttribute:
+			result.AtomicAggregate = true
+		case aggregatorAttribute:
+			if a.TypeCode == As4AggregatorAttr {
+				as4Aggregator = &v.Aggregator
+			} else {
+				result.Aggregator = &v.Aggregator
+			}
+		case communitiesAttribute:
+			result.Communities = v.Communities
+		case extendedCommunitiesAttribute:
+			result.ExtendedCommunities = v.Communities
+		case largeCommunitiesAttribute:
+			result.LargeCommunities = v.Communities
+		case onlyToCustomerAttribute:
+			result.OnlyToCustomer = v.Asn
+			result.HasOnlyToCustomer = true
+		}
+	}
+	// RFC 6793 section 4.2.3
+	if session.TwoByteAsPath && as4Aggregator != nil && result.Aggregator != nil && result.Aggregator.Asn == asTrans {
+		result.Aggregator = as4Aggregator
+	}
+	return result, nil
+}
+
+// getAs4Path returns the AS_SEQUENCE ASNs of the AS4_PATH attribute sent by speakers without four-octet ASN support.
+// The attribute is ignored if the AGGREGATOR attribute does not contain AS_TRANS (RFC 6793 section 4.2.3).
+func (u Msg) getAs4Path(session *common.LocalSession) (common.AsPath, error) {
+	var as4PathAttr *pathAttribute
+	for i, a := range u.PathAttributes {
+		switch a.TypeCode {
+		case As4PathAttr:
+			as4PathAttr = &u.PathAttributes[i]
+		case AggregatorAttr:
+			attribute, err := a.GetAttribute(session)
+			if err != nil {
+				return nil, err
+			}
+			if attribute.(aggregatorAttribute).Asn != asTrans {
+				return nil, nil
+			}
+		}
+	}
+	if as4PathAttr == nil {
+		return nil, nil
+	}
+	attribute, err := as4PathAttr.GetAttribute(session)
+	if err != nil {
+		return nil, err
+	}
+	var path common.AsPath
+	for _, segment := range attribute.(asPathAttribute).Segments {
+		if segment.PathSegmentType == AsSequence {
+			path = append(path, segment.Value...)
+		}
+	}
+	return path, nil
+}
+
+// mergeAs4Path replaces the trailing ASNs of a path received from a speaker without four-octet ASN support
+// with those of the AS4_PATH attribute
+func mergeAs4Path(path, as4Path common.AsPath) common.AsPath {
+	if len(as4Path) == 0 || len(as4Path) > len(path) {
+		return path
+	}
+	return slices.Concat(path[:len(path)-len(as4Path)], as4Path)
+}
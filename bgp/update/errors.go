@@ -0,0 +1,187 @@
+package update
+
+import (
+	"FlapAlerted/bgp/common"
+	"FlapAlerted/bgp/notification"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+/*
+- Error handling of malformed UPDATE messages as per "Revised Error Handling for BGP UPDATE Messages" https://datatracker.ietf.org/doc/html/rfc7606
+*/
+
+// ErrorAction is the approach used to handle an error in an UPDATE message, in increasing order of severity
+type ErrorAction uint8
+
+const (
+	// AttributeDiscard removes the malformed attribute from the message
+	AttributeDiscard ErrorAction = iota + 1
+	// TreatAsWithdraw handles all routes announced by the message as withdrawn
+	TreatAsWithdraw
+	// AFIDisable stops processing routes of the address family of the malformed attribute
+	AFIDisable
+	// SessionReset closes the session with a NOTIFICATION message
+	SessionReset
+)
+
+func (a ErrorAction) String() string {
+	switch a {
+	case AttributeDiscard:
+		return "attribute-discard"
+	case TreatAsWithdraw:
+		return "treat-as-withdraw"
+	case AFIDisable:
+		return "afi-safi-disable"
+	case SessionReset:
+		return "session-reset"
+	}
+	return fmt.Sprintf("unknown (%d)", uint8(a))
+}
+
+// Error is an error in an UPDATE message along with the action taken to handle it
+type Error struct {
+	Action ErrorAction
+	// Type code of the malformed attribute, zero if the error is not related to a single attribute
+	Attribute uint8
+	// Address family disabled by the AFIDisable action
+	AFI common.AFI
+	// Error subcode of the NOTIFICATION message sent by the SessionReset action
+	SubCode notification.ErrorSubCode
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Attribute != 0 {
+		return fmt.Sprintf("malformed UPDATE message (%s): attribute %d: %s", e.Action, e.Attribute, e.Err)
+	}
+	return fmt.Sprintf("malformed UPDATE message (%s): %s", e.Action, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func sessionReset(subCode notification.ErrorSubCode, err error) *Error {
+	return &Error{Action: SessionReset, SubCode: subCode, Err: err}
+}
+
+// Validate checks the path attributes of the message and applies the error handling of RFC 7606.
+// Attributes that are discarded, as well as malformed attributes handled otherwise, are removed from the message.
+// The returned errors describe how each error must be handled by the caller.
+// If an error requires a session reset, it is the only error returned.
+func (u *Msg) Validate(session *common.LocalSession) []*Error {
+	var errs []*Error
+	if u.attributeListError != nil {
+		errs = append(errs, &Error{Action: TreatAsWithdraw, Err: u.attributeListError})
+	}
+
+	seen := make(map[pathAttributeType]struct{}, len(u.PathAttributes))
+	kept := make([]pathAttribute, 0, len(u.PathAttributes))
+	for _, a := range u.PathAttributes {
+		if _, duplicate := seen[a.TypeCode]; duplicate {
+			if a.TypeCode == MultiProtocolReachableNLRIAttr || a.TypeCode == MultiProtocolUnreachableNLRIAttr {
+				return []*Error{{Action: SessionReset, Attribute: uint8(a.TypeCode), SubCode: notification.UpdateMalformedAttributeList,
+					Err: errors.New("attribute appears more than once")}}
+			}
+			errs = append(errs, &Error{Action: AttributeDiscard, Attribute: uint8(a.TypeCode), Err: errors.New("attribute appears more than once")})
+			continue
+		}
+		seen[a.TypeCode] = struct{}{}
+
+		// The local ASN is unknown (zero) for replayed sessions
+		if a.TypeCode == LocalPrefAttr && session.Asn != 0 && session.RemoteAsn != session.Asn {
+			errs = append(errs, &Error{Action: AttributeDiscard, Attribute: uint8(a.TypeCode), Err: errors.New("received from external neighbor")})
+			continue
+		}
+
+		body, err := a.check(session)
+		if err == nil {
+			// Later lookups of the attribute use the parsed body
+			a.parsed = body
+			kept = append(kept, a)
+			continue
+		}
+		e := a.errorHandling(err)
+		if e.Action == SessionReset {
+			return []*Error{e}
+		}
+		errs = append(errs, e)
+	}
+	u.PathAttributes = kept
+
+	// Missing well-known mandatory attributes
+	if _, hasMpReach := seen[MultiProtocolReachableNLRIAttr]; hasMpReach || len(u.NetworkLayerReachabilityInformation) != 0 {
+		mandatory := []pathAttributeType{OriginAttr, AsPathAttr}
+		if len(u.NetworkLayerReachabilityInformation) != 0 {
+			mandatory = append(mandatory, NextHopAttr)
+		}
+		for _, t := range mandatory {
+			if _, found := seen[t]; !found {
+				errs = append(errs, &Error{Action: TreatAsWithdraw, Attribute: uint8(t), Err: errors.New("missing well-known attribute")})
+			}
+		}
+	}
+	return errs
+}
+
+// check returns the parsed attribute or an error if the attribute is malformed. Unrecognized attributes are not checked.
+func (a pathAttribute) check(session *common.LocalSession) (pathAttributeBody, error) {
+	optional, transitive, known := a.TypeCode.expectedFlags()
+	if !known {
+		return nil, nil
+	}
+	if a.Flags.isOptional() != optional || a.Flags.isTransitive() != transitive {
+		return nil, fmt.Errorf("invalid attribute flags 0x%02x", uint8(a.Flags))
+	}
+	if a.TypeCode == OriginAttr {
+		if len(a.Body) != 1 {
+			return nil, fmt.Errorf("invalid length %d", len(a.Body))
+		}
+		if OriginType(a.Body[0]) > originUnknown {
+			return nil, fmt.Errorf("invalid origin %d", a.Body[0])
+		}
+	}
+	return a.parse(session)
+}
+
+// expectedFlags returns the optional and transitive flags that a recognized attribute must have
+func (t pathAttributeType) expectedFlags() (optional, transitive, known bool) {
+	switch t {
+	case OriginAttr, AsPathAttr, NextHopAttr, LocalPrefAttr, AtomicAggregateAttr:
+		return false, true, true
+	case AggregatorAttr, CommunitiesAttr, ExtendedCommunitiesAttr, As4PathAttr, As4AggregatorAttr, LargeCommunitiesAttr,
+		OnlyToCustomerAttr:
+		return true, true, true
+	case MultiExitDiscAttr, MultiProtocolReachableNLRIAttr, MultiProtocolUnreachableNLRIAttr:
+		return true, false, true
+	}
+	return false, false, false
+}
+
+// errorHandling returns the handling of an error of the attribute as per RFC 7606 section 7
+func (a pathAttribute) errorHandling(err error) *Error {
+	e := &Error{Attribute: uint8(a.TypeCode), Err: err}
+	switch a.TypeCode {
+	case AtomicAggregateAttr, AggregatorAttr, As4PathAttr, As4AggregatorAttr:
+		e.Action = AttributeDiscard
+	case MultiProtocolReachableNLRIAttr, MultiProtocolUnreachableNLRIAttr:
+		if len(a.Body) < 3 {
+			e.Action = SessionReset
+			e.SubCode = notification.UpdateOptionalAttributeError
+			break
+		}
+		afi, safi := common.AFI(binary.BigEndian.Uint16(a.Body)), common.SAFI(a.Body[2])
+		if (afi != common.AFI4 && afi != common.AFI6) || safi != common.UNICAST {
+			// Address families that have not been negotiated are ignored
+			e.Action = AttributeDiscard
+			break
+		}
+		e.Action = AFIDisable
+		e.AFI = afi
+	default:
+		e.Action = TreatAsWithdraw
+	}
+	return e
+}
@@ -1,5 +1,307 @@
 package update
 
+import (
+	"FlapAlerted/bgp/common"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+)
+
+const (
+	// Maximum length of a BGP message, including the header
+	MaxMessageLength = 4096
+	// Maximum length of a BGP message if the Extended Message capability has been negotiated (RFC 8654)
+	MaxExtendedMessageLength = math.MaxUint16
+
+	headerLength = 19
+)
+
+const (
+	flagOptional       pathAttributeFlags = 1 << 7
+	flagTransitive     pathAttributeFlags = 1 << 6
+	flagExtendedLength pathAttributeFlags = 1 << 4
+)
+
+// MarshalBinary encodes the message body. Prefixes are encoded without path identifiers,
+// as AddPath is only negotiated for receiving.
 func (u Msg) MarshalBinary() ([]byte, error) {
-	panic("not implemented")
+	withdrawn := appendPrefixes(nil, u.WithdrawnRoutesList)
+	if len(withdrawn) > math.MaxUint16 {
+		return nil, fmt.Errorf("withdrawn routes too long: %d bytes", len(withdrawn))
+	}
+	attributes := make([]byte, 0)
+	for _, a := range u.PathAttributes {
+		b, err := a.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		attributes = append(attributes, b...)
+	}
+	if len(attributes) > math.MaxUint16 {
+		return nil, fmt.Errorf("path attributes too long: %d bytes", len(attributes))
+	}
+
+	b := make([]byte, 0, 4+len(withdrawn)+len(attributes))
+	b = binary.BigEndian.AppendUint16(b, uint16(len(withdrawn)))
+	b = append(b, withdrawn...)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(attributes)))
+	b = append(b, attributes...)
+	return appendPrefixes(b, u.NetworkLayerReachabilityInformation), nil
+}
+
+func (a pathAttribute) MarshalBinary() ([]byte, error) {
+	if len(a.Body) > math.MaxUint16 {
+		return nil, fmt.Errorf("attribute %d too long: %d bytes", a.TypeCode, len(a.Body))
+	}
+	flags := a.Flags &^ flagExtendedLength
+	if len(a.Body) > math.MaxUint8 {
+		flags |= flagExtendedLength
+	}
+	b := make([]byte, 0, 4+len(a.Body))
+	b = append(b, byte(flags), byte(a.TypeCode))
+	if flags.isExtendedLength() {
+		b = binary.BigEndian.AppendUint16(b, uint16(len(a.Body)))
+	} else {
+		b = append(b, uint8(len(a.Body)))
+	}
+	return append(b, a.Body...), nil
+}
+
+// newAttribute returns a recognized attribute with the flags it requires
+func newAttribute(t pathAttributeType, body []byte) pathAttribute {
+	optional, transitive, _ := t.expectedFlags()
+	var flags pathAttributeFlags
+	if optional {
+		flags |= flagOptional
+	}
+	if transitive {
+		flags |= flagTransitive
+	}
+	return pathAttribute{Flags: flags, TypeCode: t, Body: body}
+}
+
+func appendPrefixes(b []byte, prefixes []prefix) []byte {
+	for _, p := range prefixes {
+		b = appendPrefix(b, p.Cidr)
+	}
+	return b
+}
+
+func appendPrefix(b []byte, p netip.Prefix) []byte {
+	b = append(b, uint8(p.Bits()))
+	return append(b, p.Masked().Addr().AsSlice()[:prefixSize(p)-1]...)
+}
+
+// prefixSize is the encoded size of a prefix without path identifier
+func prefixSize(p netip.Prefix) int {
+	return 1 + (p.Bits()+7)/8
+}
+
+// GetAnnounce returns the UPDATE messages announcing the prefixes of the address family with the given path.
+// IPv4 prefixes with an IPv4 next hop are announced in the NLRI field, all others in the MP_REACH_NLRI attribute (RFC 4760).
+// The prefixes are split across messages of at most maxLength bytes.
+func GetAnnounce(afi common.AFI, prefixes []netip.Prefix, asPath common.AsPath, attributes *common.PathAttributes, maxLength int) ([][]byte, error) {
+	pathAttributes := announceAttributes(asPath, attributes)
+
+	multiProtocol := afi != common.AFI4 || !attributes.NextHop.Is4()
+	var nextHop []byte
+	if multiProtocol {
+		if !attributes.NextHop.IsValid() {
+			return nil, errors.New("next hop not specified")
+		}
+		nextHop = attributes.NextHop.AsSlice()
+		if attributes.NextHop.Is4() {
+			// IPv4-mapped IPv6 address for IPv6 routes announced over IPv4
+			addr := netip.AddrFrom16(attributes.NextHop.As16())
+			nextHop = addr.AsSlice()
+		}
+		if attributes.LinkLocalNextHop.IsValid() {
+			nextHop = append(nextHop, attributes.LinkLocalNextHop.AsSlice()...)
+		}
+	} else {
+		pathAttributes = append(pathAttributes, newAttribute(NextHopAttr, attributes.NextHop.AsSlice()))
+	}
+
+	fixedLength := headerLength + 4 + attributesLength(pathAttributes)
+	if multiProtocol {
+		// Extended length attribute header, AFI, SAFI, next hop length, next hop and reserved octet
+		fixedLength += 4 + 4 + len(nextHop) + 1
+	}
+
+	chunks, err := splitPrefixes(prefixes, maxLength-fixedLength)
+	if err != nil {
+		return nil, err
+	}
+	messages := make([][]byte, 0, len(chunks))
+	for _, chunk := range chunks {
+		msg := Msg{}
+		if multiProtocol {
+			body := binary.BigEndian.AppendUint16(nil, uint16(afi))
+			body = append(body, byte(common.UNICAST), uint8(len(nextHop)))
+			body = append(body, nextHop...)
+			body = append(body, 0)
+			body = appendPrefixes(body, chunk)
+			// MP_REACH_NLRI is encoded first as recommended by RFC 7606 section 5.1
+			msg.PathAttributes = append([]pathAttribute{newAttribute(MultiProtocolReachableNLRIAttr, body)}, pathAttributes...)
+		} else {
+			msg.PathAttributes = pathAttributes
+			msg.NetworkLayerReachabilityInformation = chunk
+		}
+		b, err := marshalUpdate(msg)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, b)
+	}
+	return messages, nil
+}
+
+// GetWithdraw returns the UPDATE messages withdrawing the prefixes of the address family.
+// The prefixes are split across messages of at most maxLength bytes.
+func GetWithdraw(afi common.AFI, prefixes []netip.Prefix, maxLength int) ([][]byte, error) {
+	fixedLength := headerLength + 4
+	if afi != common.AFI4 {
+		// Extended length attribute header, AFI and SAFI
+		fixedLength += 4 + 3
+	}
+	chunks, err := splitPrefixes(prefixes, maxLength-fixedLength)
+	if err != nil {
+		return nil, err
+	}
+	messages := make([][]byte, 0, len(chunks))
+	for _, chunk := range chunks {
+		msg := Msg{}
+		if afi != common.AFI4 {
+			msg.PathAttributes = []pathAttribute{newAttribute(MultiProtocolUnreachableNLRIAttr, multiProtocolUnreachableBody(afi, chunk))}
+		} else {
+			msg.WithdrawnRoutesList = chunk
+		}
+		b, err := marshalUpdate(msg)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, b)
+	}
+	return messages, nil
+}
+
+// GetEndOfRIB returns the End-of-RIB marker of the address family (RFC 4724 section 2)
+func GetEndOfRIB(afi common.AFI) ([]byte, error) {
+	msg := Msg{}
+	if afi != common.AFI4 {
+		msg.PathAttributes = []pathAttribute{newAttribute(MultiProtocolUnreachableNLRIAttr, multiProtocolUnreachableBody(afi, nil))}
+	}
+	return marshalUpdate(msg)
+}
+
+func marshalUpdate(msg Msg) ([]byte, error) {
+	m := common.BgpMessage{
+		Header: common.GetHeader(common.MsgUpdate),
+		Body:   msg,
+	}
+	return m.MarshalBinary()
+}
+
+func multiProtocolUnreachableBody(afi common.AFI, prefixes []prefix) []byte {
+	body := binary.BigEndian.AppendUint16(nil, uint16(afi))
+	body = append(body, byte(common.UNICAST))
+	return appendPrefixes(body, prefixes)
+}
+
+// announceAttributes returns the attributes of announced routes other than the next hop and MP_REACH_NLRI
+func announceAttributes(asPath common.AsPath, a *common.PathAttributes) []pathAttribute {
+	result := []pathAttribute{
+		newAttribute(OriginAttr, []byte{byte(a.Origin)}),
+		newAttribute(AsPathAttr, asPathBody(asPath)),
+	}
+	if a.HasMultiExitDisc {
+		result = append(result, newAttribute(MultiExitDiscAttr, binary.BigEndian.AppendUint32(nil, a.MultiExitDisc)))
+	}
+	if a.HasLocalPref {
+		result = append(result, newAttribute(LocalPrefAttr, binary.BigEndian.AppendUint32(nil, a.LocalPref)))
+	}
+	if a.AtomicAggregate {
+		result = append(result, newAttribute(AtomicAggregateAttr, []byte{}))
+	}
+	if a.Aggregator != nil {
+		body := binary.BigEndian.AppendUint32(nil, a.Aggregator.Asn)
+		result = append(result, newAttribute(AggregatorAttr, append(body, a.Aggregator.Address.AsSlice()...)))
+	}
+	if len(a.Communities) != 0 {
+		body := make([]byte, 0, 4*len(a.Communities))
+		for _, c := range a.Communities {
+			body = binary.BigEndian.AppendUint32(body, c)
+		}
+		result = append(result, newAttribute(CommunitiesAttr, body))
+	}
+	if len(a.ExtendedCommunities) != 0 {
+		body := make([]byte, 0, 8*len(a.ExtendedCommunities))
+		for _, c := range a.ExtendedCommunities {
+			body = binary.BigEndian.AppendUint64(body, c)
+		}
+		result = append(result, newAttribute(ExtendedCommunitiesAttr, body))
+	}
+	if len(a.LargeCommunities) != 0 {
+		body := make([]byte, 0, 12*len(a.LargeCommunities))
+		for _, c := range a.LargeCommunities {
+			body = binary.BigEndian.AppendUint32(body, c.GlobalAdministrator)
+			body = binary.BigEndian.AppendUint32(body, c.LocalData1)
+			body = binary.BigEndian.AppendUint32(body, c.LocalData2)
+		}
+		result = append(result, newAttribute(LargeCommunitiesAttr, body))
+	}
+	if a.HasOnlyToCustomer {
+		result = append(result, newAttribute(OnlyToCustomerAttr, binary.BigEndian.AppendUint32(nil, a.OnlyToCustomer)))
+	}
+	return result
+}
+
+// asPathBody encodes the AS path as AS_SEQUENCE segments of four-octet ASNs
+func asPathBody(asPath common.AsPath) []byte {
+	body := make([]byte, 0, 2*(len(asPath)/math.MaxUint8+1)+4*len(asPath))
+	for len(asPath) > 0 {
+		segment := asPath[:min(len(asPath), math.MaxUint8)]
+		asPath = asPath[len(segment):]
+		body = append(body, byte(AsSequence), uint8(len(segment)))
+		for _, asn := range segment {
+			body = binary.BigEndian.AppendUint32(body, asn)
+		}
+	}
+	return body
+}
+
+func attributesLength(attributes []pathAttribute) int {
+	length := 0
+	for _, a := range attributes {
+		length += 3 + len(a.Body)
+		if len(a.Body) > math.MaxUint8 {
+			length++
+		}
+	}
+	return length
+}
+
+// splitPrefixes splits the prefixes into lists whose encoding does not exceed the given number of bytes
+func splitPrefixes(prefixes []netip.Prefix, maxBytes int) ([][]prefix, error) {
+	// Size of the longest IPv6 prefix
+	if maxBytes < 17 {
+		return nil, errors.New("attributes exceed the maximum message length")
+	}
+	chunks := make([][]prefix, 0, 1)
+	var chunk []prefix
+	size := 0
+	for _, p := range prefixes {
+		if size+prefixSize(p) > maxBytes {
+			chunks = append(chunks, chunk)
+			chunk, size = nil, 0
+		}
+		chunk = append(chunk, prefix{Cidr: p})
+		size += prefixSize(p)
+	}
+	if len(chunk) != 0 {
+		chunks = append(chunks, chunk)
+	}
+	return chunks, nil
 }
@@ -11,19 +11,25 @@ type Msg struct {
 	TotalPathAttributeLength            uint16
 	PathAttributes                      []pathAttribute
 	NetworkLayerReachabilityInformation []prefix
+
+	// Error that prevented parsing all path attributes, the routes of the message are treated as withdrawn
+	attributeListError error
 }
 
 type prefix struct {
-	AFI        common.AFI // Added
-	PathID     uint32
-	LengthBits uint8
-	Prefix     []byte
+	PathID uint32
+	Cidr   netip.Prefix
 }
 
 type pathAttribute struct {
 	Flags    pathAttributeFlags
 	TypeCode pathAttributeType
-	Body     []byte
+	// References the buffer of the message
+	Body []byte
+	// Body parsed by Msg.Validate, nil if not yet parsed
+	parsed pathAttributeBody
+	// Body is cut off by the end of the attribute list, only kept for MP_REACH_NLRI to withdraw the complete prefixes
+	truncated bool
 }
 
 type pathAttributeFlags byte
@@ -35,15 +41,31 @@ type pathAttributeType uint8
 const (
 	OriginAttr                       pathAttributeType = 1
 	AsPathAttr                       pathAttributeType = 2
+	NextHopAttr                      pathAttributeType = 3
+	MultiExitDiscAttr                pathAttributeType = 4
+	LocalPrefAttr                    pathAttributeType = 5
+	AtomicAggregateAttr              pathAttributeType = 6
+	AggregatorAttr                   pathAttributeType = 7
+	CommunitiesAttr                  pathAttributeType = 8
 	MultiProtocolReachableNLRIAttr   pathAttributeType = 14
 	MultiProtocolUnreachableNLRIAttr pathAttributeType = 15
+	ExtendedCommunitiesAttr          pathAttributeType = 16
+	As4PathAttr                      pathAttributeType = 17
+	As4AggregatorAttr                pathAttributeType = 18
+	LargeCommunitiesAttr             pathAttributeType = 32
+	OnlyToCustomerAttr               pathAttributeType = 35
 )
 
+// ASN used in place of four-octet ASNs by speakers without four-octet ASN support (RFC 6793)
+const asTrans = 23456
+
 type pathSegmentType uint8
 
 const (
-	AsSet      pathSegmentType = 1
-	AsSequence pathSegmentType = 2
+	AsSet            pathSegmentType = 1
+	AsSequence       pathSegmentType = 2
+	AsConfedSequence pathSegmentType = 3 // RFC 5065
+	AsConfedSet      pathSegmentType = 4 // RFC 5065
 )
 
 type MPReachNLRI struct {
@@ -82,3 +104,37 @@ type asPathAttributeSegment struct {
 	PathSegmentCount uint8
 	Value            common.AsPath
 }
+
+type nextHopAttribute struct {
+	NextHop netip.Addr
+}
+
+type multiExitDiscAttribute struct {
+	Value uint32
+}
+
+type localPrefAttribute struct {
+	Value uint32
+}
+
+type atomicAggregateAttribute struct{}
+
+type aggregatorAttribute struct {
+	common.Aggregator
+}
+
+type communitiesAttribute struct {
+	Communities []uint32
+}
+
+type extendedCommunitiesAttribute struct {
+	Communities []uint64
+}
+
+type largeCommunitiesAttribute struct {
+	Communities []common.LargeCommunity
+}
+
+type onlyToCustomerAttribute struct {
+	Asn uint32
+}
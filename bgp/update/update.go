@@ -2,7 +2,7 @@ package update
 
 import (
 	"FlapAlerted/bgp/common"
-	"bytes"
+	"FlapAlerted/bgp/notification"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -10,145 +10,187 @@ import (
 	"net/netip"
 )
 
-func ParseMsgUpdate(r io.Reader, defaultAFI common.AFI, addPathEnabled bool) (msg Msg, err error) {
-	msg = Msg{}
-	if err := binary.Read(r, binary.BigEndian, &msg.WithdrawnRoutesLength); err != nil {
-		return Msg{}, err
+// ParseMsgUpdate parses an UPDATE message. Errors in the path attributes are handled by Msg.Validate,
+// the returned errors are of type *Error and require a session reset.
+func ParseMsgUpdate(r io.Reader, defaultAFI common.AFI, addPathEnabled bool) (Msg, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return Msg{}, sessionReset(notification.UpdateMalformedAttributeList, err)
 	}
+	return DecodeMsgUpdate(b, defaultAFI, addPathEnabled)
+}
 
-	// Reader for withdrawn routes list
-	wR := io.LimitReader(r, int64(msg.WithdrawnRoutesLength))
-	msg.WithdrawnRoutesList, err = parsePrefixList(wR, defaultAFI, addPathEnabled)
+// DecodeMsgUpdate parses the UPDATE message body in a single pass without copying it.
+// The returned message references b, which must not be modified afterward.
+func DecodeMsgUpdate(b []byte, defaultAFI common.AFI, addPathEnabled bool) (msg Msg, err error) {
+	if len(b) < 2 {
+		return Msg{}, sessionReset(notification.UpdateMalformedAttributeList, io.ErrUnexpectedEOF)
+	}
+	msg.WithdrawnRoutesLength = binary.BigEndian.Uint16(b)
+	b = b[2:]
+	if int(msg.WithdrawnRoutesLength) > len(b) {
+		return Msg{}, sessionReset(notification.UpdateMalformedAttributeList, fmt.Errorf("withdrawn routes length exceeds message: %w", io.ErrUnexpectedEOF))
+	}
+	msg.WithdrawnRoutesList, err = parsePrefixList(b[:msg.WithdrawnRoutesLength], defaultAFI, addPathEnabled)
 	if err != nil {
-		return Msg{}, err
+		return Msg{}, sessionReset(notification.UpdateInvalidNetworkField, fmt.Errorf("error parsing withdrawn routes: %w", err))
 	}
+	b = b[msg.WithdrawnRoutesLength:]
 
-	if err := binary.Read(r, binary.BigEndian, &msg.TotalPathAttributeLength); err != nil {
-		return Msg{}, err
+	if len(b) < 2 {
+		return Msg{}, sessionReset(notification.UpdateMalformedAttributeList, io.ErrUnexpectedEOF)
 	}
+	msg.TotalPathAttributeLength = binary.BigEndian.Uint16(b)
+	b = b[2:]
+	if int(msg.TotalPathAttributeLength) > len(b) {
+		return Msg{}, sessionReset(notification.UpdateMalformedAttributeList, fmt.Errorf("total path attribute length exceeds message: %w", io.ErrUnexpectedEOF))
+	}
+	msg.PathAttributes, msg.attributeListError = parsePathAttributes(b[:msg.TotalPathAttributeLength])
 
-	msg.PathAttributes = make([]pathAttribute, 0)
-
-	// Reader for attributes
-	aR := io.LimitReader(r, int64(msg.TotalPathAttributeLength))
+	msg.NetworkLayerReachabilityInformation, err = parsePrefixList(b[msg.TotalPathAttributeLength:], defaultAFI, addPathEnabled)
+	if err != nil {
+		return Msg{}, sessionReset(notification.UpdateInvalidNetworkField, fmt.Errorf("error parsing NLRI: %w", err))
+	}
+	return msg, nil
+}
 
-	for {
-		attribute := pathAttribute{}
-		if err := binary.Read(aR, binary.BigEndian, &attribute.Flags); err != nil {
-			if errors.Is(err, io.EOF) {
-				break
-			}
-			return Msg{}, err
+// parsePathAttributes splits the path attributes. If the length of an attribute is inconsistent with the
+// total path attribute length, the attributes parsed until then are returned along with an error.
+func parsePathAttributes(b []byte) ([]pathAttribute, error) {
+	// Most messages carry less than eight attributes
+	result := make([]pathAttribute, 0, 8)
+	for len(b) > 0 {
+		if len(b) < 3 {
+			return result, errors.New("truncated path attribute header")
 		}
-		if err := binary.Read(aR, binary.BigEndian, &attribute.TypeCode); err != nil {
-			return Msg{}, err
+		attribute := pathAttribute{
+			Flags:    pathAttributeFlags(b[0]),
+			TypeCode: pathAttributeType(b[1]),
 		}
-
-		var bodyLength uint16
+		var bodyLength int
 		if attribute.Flags.isExtendedLength() {
-			var length uint16
-			if err := binary.Read(aR, binary.BigEndian, &length); err != nil {
-				return Msg{}, err
+			if len(b) < 4 {
+				return result, errors.New("truncated path attribute header")
 			}
-			bodyLength = length
+			bodyLength = int(binary.BigEndian.Uint16(b[2:]))
+			b = b[4:]
 		} else {
-			var length uint8
-			if err := binary.Read(aR, binary.BigEndian, &length); err != nil {
-				return Msg{}, err
-			}
-			bodyLength = uint16(length)
+			bodyLength = int(b[2])
+			b = b[3:]
 		}
 
-		attribute.Body = make([]byte, bodyLength)
-
-		if _, err := io.ReadFull(aR, attribute.Body); err != nil {
-			return Msg{}, err
+		if bodyLength > len(b) {
+			if attribute.TypeCode == MultiProtocolReachableNLRIAttr {
+				// The prefixes before the truncation point are withdrawn along with the other routes of the message
+				attribute.Body = b[:len(b):len(b)]
+				attribute.truncated = true
+				result = append(result, attribute)
+			}
+			return result, fmt.Errorf("length of attribute %d exceeds total path attribute length", attribute.TypeCode)
 		}
-		msg.PathAttributes = append(msg.PathAttributes, attribute)
+		attribute.Body = b[:bodyLength:bodyLength]
+		b = b[bodyLength:]
+		result = append(result, attribute)
 	}
-
-	msg.NetworkLayerReachabilityInformation, err = parsePrefixList(r, defaultAFI, addPathEnabled)
-	if err != nil {
-		return Msg{}, err
-	}
-	return msg, nil
+	return result, nil
 }
 
-func parsePrefixList(r io.Reader, afi common.AFI, addPathEnabled bool) ([]prefix, error) {
-	prefixList := make([]prefix, 0)
-	for {
-		p := prefix{}
-		p.AFI = afi
-
+// parsePrefixList decodes the prefixes of the list. A prefix is decoded without allocations,
+// only the list itself is allocated. On error, the prefixes decoded before the error are returned as well.
+func parsePrefixList(b []byte, afi common.AFI, addPathEnabled bool) ([]prefix, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+	prefixList := make([]prefix, 0, len(b)/estimatedPrefixSize(afi, addPathEnabled)+1)
+	maxLength := maxPrefixLength(afi)
+	for len(b) > 0 {
+		var pathID uint32
 		if addPathEnabled {
-			if err := binary.Read(r, binary.BigEndian, &p.PathID); err != nil {
-				if errors.Is(err, io.EOF) {
-					break
-				}
-				return nil, err
-			}
-
-			if err := binary.Read(r, binary.BigEndian, &p.LengthBits); err != nil {
-				return nil, err
-			}
-		} else {
-			if err := binary.Read(r, binary.BigEndian, &p.LengthBits); err != nil {
-				if errors.Is(err, io.EOF) {
-					break
-				}
-				return nil, err
+			if len(b) < 4 {
+				return prefixList, io.ErrUnexpectedEOF
 			}
+			pathID = binary.BigEndian.Uint32(b)
+			b = b[4:]
 		}
-
-		var additive uint8 = 0
-		if p.LengthBits%8 != 0 {
-			additive += 1
+		if len(b) == 0 {
+			return prefixList, io.ErrUnexpectedEOF
+		}
+		lengthBits := b[0]
+		b = b[1:]
+		if lengthBits > maxLength {
+			return prefixList, fmt.Errorf("invalid prefix length %d", lengthBits)
 		}
-		expectedLength := (p.LengthBits / 8) + additive
-		p.Prefix = make([]byte, expectedLength)
-		if _, err := io.ReadFull(r, p.Prefix); err != nil {
-			return nil, err
+		byteLength := int(lengthBits+7) / 8
+		if byteLength > len(b) {
+			return prefixList, io.ErrUnexpectedEOF
 		}
-		prefixList = append(prefixList, p)
+
+		var addr netip.Addr
+		if afi == common.AFI6 {
+			var addrBytes [16]byte
+			copy(addrBytes[:], b[:byteLength])
+			addr = netip.AddrFrom16(addrBytes)
+		} else {
+			var addrBytes [4]byte
+			copy(addrBytes[:], b[:byteLength])
+			addr = netip.AddrFrom4(addrBytes)
+		}
+		b = b[byteLength:]
+		prefixList = append(prefixList, prefix{PathID: pathID, Cidr: netip.PrefixFrom(addr, int(lengthBits))})
 	}
 	return prefixList, nil
 }
 
+// estimatedPrefixSize is the encoded size of a typical prefix (/24 or /48), used to size prefix lists
+func estimatedPrefixSize(afi common.AFI, addPathEnabled bool) int {
+	size := 4
+	if afi == common.AFI6 {
+		size = 7
+	}
+	if addPathEnabled {
+		size += 4
+	}
+	return size
+}
+
+func maxPrefixLength(afi common.AFI) uint8 {
+	if afi == common.AFI6 {
+		return 128
+	}
+	return 32
+}
+
 func (f pathAttributeFlags) isOptional() bool {
-	return isBitSet(byte(f), 1)
+	return isBitSet(byte(f), 7)
 }
 func (f pathAttributeFlags) isWellKnown() bool {
-	return !isBitSet(byte(f), 1)
+	return !isBitSet(byte(f), 7)
 }
 func (f pathAttributeFlags) isTransitive() bool {
-	return !isBitSet(byte(f), 2)
+	return isBitSet(byte(f), 6)
 }
 func (f pathAttributeFlags) isPartial() bool {
-	return !isBitSet(byte(f), 3)
+	return isBitSet(byte(f), 5)
 }
 func (f pathAttributeFlags) isExtendedLength() bool {
 	return isBitSet(byte(f), 4)
 }
 
 func parseMultiProtocolUnreachableNLRI(a pathAttribute, session *common.LocalSession) (pathAttributeBody, error) {
-	if !a.Flags.isWellKnown() {
-		return originAttribute{}, errors.New("well-known flag not set")
+	b := a.Body
+	if len(b) < 3 {
+		return nil, io.ErrUnexpectedEOF
 	}
-	r := bytes.NewReader(a.Body)
-	result := MPUnReachNLRI{}
-	if err := binary.Read(r, binary.BigEndian, &result.AFI); err != nil {
-		return nil, err
-	}
-	if err := binary.Read(r, binary.BigEndian, &result.SAFI); err != nil {
-		return nil, err
+	result := MPUnReachNLRI{
+		AFI:  common.AFI(binary.BigEndian.Uint16(b)),
+		SAFI: common.SAFI(b[2]),
 	}
-
 	if (result.AFI != common.AFI4 && result.AFI != common.AFI6) || result.SAFI != common.UNICAST {
 		return nil, errors.New("unknown <AFI,SAFI> combination")
 	}
 
 	var err error
-	result.Withdrawn, err = parsePrefixList(r, result.AFI, session.AddPathEnabled)
+	result.Withdrawn, err = parsePrefixList(b[3:], result.AFI, session.AddPathEnabled)
 	if err != nil {
 		return nil, err
 	}
@@ -156,31 +198,24 @@ func parseMultiProtocolUnreachableNLRI(a pathAttribute, session *common.LocalSes
 }
 
 func parseMultiProtocolReachableNLRI(a pathAttribute, session *common.LocalSession) (pathAttributeBody, error) {
-	if !a.Flags.isWellKnown() {
-		return nil, errors.New("well-known flag not set")
-	}
-	r := bytes.NewReader(a.Body)
-	result := MPReachNLRI{}
-	if err := binary.Read(r, binary.BigEndian, &result.AFI); err != nil {
-		return nil, err
+	b := a.Body
+	if len(b) < 4 {
+		return nil, io.ErrUnexpectedEOF
 	}
-	if err := binary.Read(r, binary.BigEndian, &result.SAFI); err != nil {
-		return nil, err
+	result := MPReachNLRI{
+		AFI:           common.AFI(binary.BigEndian.Uint16(b)),
+		SAFI:          common.SAFI(b[2]),
+		NextHopLength: b[3],
 	}
-
 	if (result.AFI != common.AFI4 && result.AFI != common.AFI6) || result.SAFI != common.UNICAST {
 		return nil, errors.New("unknown <AFI,SAFI> combination")
 	}
-
-	if err := binary.Read(r, binary.BigEndian, &result.NextHopLength); err != nil {
-		return nil, err
-	}
-
-	afiReader := io.LimitReader(r, int64(result.NextHopLength))
-
-	if result.NextHopLength == 0 {
-		result.NextHop = make([]netip.Addr, 0)
+	b = b[4:]
+	if int(result.NextHopLength) > len(b) {
+		return nil, io.ErrUnexpectedEOF
 	}
+	nextHops := b[:result.NextHopLength]
+	b = b[result.NextHopLength:]
 
 	// Extended next hops
 	nextHopAfi := result.AFI
@@ -191,94 +226,122 @@ func parseMultiProtocolReachableNLRI(a pathAttribute, session *common.LocalSessi
 	}
 
 	if nextHopAfi == common.AFI4 {
-		result.NextHop = make([]netip.Addr, 0, result.NextHopLength/4)
-		ip := [4]byte{}
-		for {
-			if _, err := io.ReadFull(afiReader, ip[:]); err != nil {
-				if errors.Is(err, io.EOF) {
-					break
-				}
-				return nil, err
-			}
-			result.NextHop = append(result.NextHop, netip.AddrFrom4(ip))
+		if len(nextHops)%4 != 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		result.NextHop = make([]netip.Addr, 0, len(nextHops)/4)
+		for ; len(nextHops) > 0; nextHops = nextHops[4:] {
+			result.NextHop = append(result.NextHop, netip.AddrFrom4([4]byte(nextHops)))
 		}
 	} else {
-		result.NextHop = make([]netip.Addr, 0, result.NextHopLength/16)
-		ip := [16]byte{}
-		for {
-			if _, err := io.ReadFull(afiReader, ip[:]); err != nil {
-				if errors.Is(err, io.EOF) {
-					break
-				}
-				return nil, err
-			}
-			result.NextHop = append(result.NextHop, netip.AddrFrom16(ip))
+		if len(nextHops)%16 != 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		result.NextHop = make([]netip.Addr, 0, len(nextHops)/16)
+		for ; len(nextHops) > 0; nextHops = nextHops[16:] {
+			result.NextHop = append(result.NextHop, netip.AddrFrom16([16]byte(nextHops)))
 		}
 	}
 
-	if err := binary.Read(r, binary.BigEndian, &result.Reserved); err != nil {
-		return nil, err
+	if len(b) == 0 {
+		return nil, io.ErrUnexpectedEOF
 	}
+	result.Reserved = b[0]
 
 	var err error
-	result.NLRI, err = parsePrefixList(r, result.AFI, session.AddPathEnabled)
-	if err != nil {
+	result.NLRI, err = parsePrefixList(b[1:], result.AFI, session.AddPathEnabled)
+	if err != nil && !a.truncated {
 		return nil, fmt.Errorf("error parsing prefixList: %w", err)
 	}
 	return result, nil
 }
 
 func parseOriginAttribute(a pathAttribute) (pathAttributeBody, error) {
-	if !a.Flags.isWellKnown() {
-		return nil, errors.New("well-known flag not set")
-	}
-	r := bytes.NewReader(a.Body)
-	result := originAttribute{}
-	if err := binary.Read(r, binary.BigEndian, &result); err != nil {
-		return nil, err
+	if len(a.Body) == 0 {
+		return nil, io.ErrUnexpectedEOF
 	}
-	return result, nil
+	return originAttribute{Origin: OriginType(a.Body[0])}, nil
 }
 
-func parseAsPathAttribute(a pathAttribute) (pathAttributeBody, error) {
-	if !a.Flags.isWellKnown() {
-		return nil, errors.New("well-known flag not set")
+func parseAsPathAttribute(a pathAttribute, twoByteAsn bool) (pathAttributeBody, error) {
+	asnSize := 4
+	if twoByteAsn {
+		asnSize = 2
 	}
-	r := bytes.NewReader(a.Body)
+	b := a.Body
 	result := asPathAttribute{
 		Segments: make([]asPathAttributeSegment, 0, 1),
 	}
-	for {
-		newSegment := asPathAttributeSegment{}
-		if err := binary.Read(r, binary.BigEndian, &newSegment.PathSegmentType); err != nil {
-			if errors.Is(err, io.EOF) {
-				break
-			}
-			return nil, err
+	for len(b) > 0 {
+		newSegment := asPathAttributeSegment{PathSegmentType: pathSegmentType(b[0])}
+		if newSegment.PathSegmentType < AsSet || newSegment.PathSegmentType > AsConfedSet {
+			return nil, fmt.Errorf("invalid segment type %d", newSegment.PathSegmentType)
 		}
-		if err := binary.Read(r, binary.BigEndian, &newSegment.PathSegmentCount); err != nil {
-			return nil, err
+		if len(b) < 2 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		newSegment.PathSegmentCount = b[1]
+		if newSegment.PathSegmentCount == 0 {
+			return nil, errors.New("empty segment")
+		}
+		b = b[2:]
+		if len(b) < int(newSegment.PathSegmentCount)*asnSize {
+			return nil, io.ErrUnexpectedEOF
 		}
 		newSegment.Value = make([]uint32, newSegment.PathSegmentCount)
-		for i := 0; i < int(newSegment.PathSegmentCount); i++ {
-			var asn uint32
-			if err := binary.Read(r, binary.BigEndian, &asn); err != nil {
-				return nil, err
+		for i := range newSegment.Value {
+			if twoByteAsn {
+				newSegment.Value[i] = uint32(binary.BigEndian.Uint16(b[i*2:]))
+			} else {
+				newSegment.Value[i] = binary.BigEndian.Uint32(b[i*4:])
 			}
-			newSegment.Value[i] = asn
 		}
+		b = b[int(newSegment.PathSegmentCount)*asnSize:]
 		result.Segments = append(result.Segments, newSegment)
 	}
 
 	return result, nil
 }
 
+// GetAttribute returns the parsed attribute body. Attributes are only parsed again if they have not been checked by Msg.Validate.
 func (a pathAttribute) GetAttribute(session *common.LocalSession) (pathAttributeBody, error) {
+	if a.parsed != nil {
+		return a.parsed, nil
+	}
+	return a.parse(session)
+}
+
+func (a pathAttribute) parse(session *common.LocalSession) (pathAttributeBody, error) {
 	switch a.TypeCode {
 	case OriginAttr:
 		return parseOriginAttribute(a)
 	case AsPathAttr:
-		return parseAsPathAttribute(a)
+		return parseAsPathAttribute(a, session.TwoByteAsPath)
+	case NextHopAttr:
+		return parseNextHopAttribute(a)
+	case MultiExitDiscAttr:
+		value, err := parseUint32Attribute(a)
+		return multiExitDiscAttribute{Value: value}, err
+	case LocalPrefAttr:
+		value, err := parseUint32Attribute(a)
+		return localPrefAttribute{Value: value}, err
+	case AtomicAggregateAttr:
+		return parseAtomicAggregateAttribute(a)
+	case AggregatorAttr:
+		return parseAggregatorAttribute(a, session.TwoByteAsPath)
+	case CommunitiesAttr:
+		return parseCommunitiesAttribute(a)
+	case ExtendedCommunitiesAttr:
+		return parseExtendedCommunitiesAttribute(a)
+	case As4PathAttr:
+		return parseAsPathAttribute(a, false)
+	case As4AggregatorAttr:
+		return parseAggregatorAttribute(a, false)
+	case LargeCommunitiesAttr:
+		return parseLargeCommunitiesAttribute(a)
+	case OnlyToCustomerAttr:
+		value, err := parseUint32Attribute(a)
+		return onlyToCustomerAttribute{Asn: value}, err
 	case MultiProtocolReachableNLRIAttr:
 		return parseMultiProtocolReachableNLRI(a, session)
 	case MultiProtocolUnreachableNLRIAttr:
@@ -344,31 +407,39 @@ OuterLoop:
 		return nil, false, nil
 	}
 
+	if session.TwoByteAsPath {
+		as4Path, err := u.getAs4Path(session)
+		if err != nil {
+			return nil, false, fmt.Errorf("error parsing AS4_PATH: %w", err)
+		}
+		path = mergeAs4Path(path, as4Path)
+	}
+
 	if len(path) == 0 {
 		// The AS path can be completely empty in case of iBGP when in the same ASN
-		path = append(path, session.Asn)
+		path = append(path, session.RemoteAsn)
 	}
 	return path, true, nil
 }
 
-func (p prefix) ToNetCidr() netip.Prefix {
-	switch p.AFI {
-	case common.AFI6:
-		if len(p.Prefix) > 16 {
-			return netip.MustParsePrefix("::/0")
-		}
-		var addrBytes [16]byte
-		copy(addrBytes[:], p.Prefix)
-		addr := netip.AddrFrom16(addrBytes)
-		return netip.PrefixFrom(addr, int(p.LengthBits))
-	case common.AFI4:
-		if len(p.Prefix) > 4 {
-			return netip.MustParsePrefix("0.0.0.0/0")
+// GetEndOfRIB returns the address family if the message is an End-of-RIB marker (RFC 4724 section 2)
+func (u Msg) GetEndOfRIB() (common.AFI, bool) {
+	if len(u.WithdrawnRoutesList) != 0 || len(u.NetworkLayerReachabilityInformation) != 0 {
+		return 0, false
+	}
+	if len(u.PathAttributes) == 0 {
+		return common.AFI4, true
+	}
+	// An MP_UNREACH_NLRI attribute with no withdrawn routes
+	if len(u.PathAttributes) == 1 && u.PathAttributes[0].TypeCode == MultiProtocolUnreachableNLRIAttr {
+		body := u.PathAttributes[0].Body
+		if len(body) == 3 && common.SAFI(body[2]) == common.UNICAST {
+			return common.AFI(binary.BigEndian.Uint16(body[:2])), true
 		}
-		var addrBytes [4]byte
-		copy(addrBytes[:], p.Prefix)
-		addr := netip.AddrFrom4(addrBytes)
-		return netip.PrefixFrom(addr, int(p.LengthBits))
 	}
-	return netip.MustParsePrefix("::/0")
+	return 0, false
+}
+
+func (p prefix) ToNetCidr() netip.Prefix {
+	return p.Cidr
 }
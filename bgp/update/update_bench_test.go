@@ -0,0 +1,140 @@
+package update
+
+import (
+	"FlapAlerted/bgp/common"
+	"encoding/binary"
+	"math/rand/v2"
+	"testing"
+)
+
+const (
+	// Number of messages of the synthetic full table dump
+	fixtureMessageCount = 4000
+	// Share of messages announcing IPv6 prefixes in MP_REACH_NLRI
+	fixtureIPv6Share = 0.2
+)
+
+// fixtureUpdates returns the bodies of the UPDATE messages of a synthetic full table dump received on a session
+// with AddPath enabled for both address families. The messages are the same on every call.
+func fixtureUpdates() [][]byte {
+	r := rand.New(rand.NewPCG(1, 2))
+	messages := make([][]byte, 0, fixtureMessageCount)
+	for i := range fixtureMessageCount {
+		if r.Float64() < fixtureIPv6Share {
+			messages = append(messages, fixtureIPv6Update(r, i))
+		} else {
+			messages = append(messages, fixtureIPv4Update(r, i))
+		}
+	}
+	return messages
+}
+
+func fixtureAttribute(flags, code byte, value []byte) []byte {
+	b := []byte{flags, code, byte(len(value))}
+	return append(b, value...)
+}
+
+// fixtureCommonAttributes returns ORIGIN, AS_PATH, MED, LOCAL_PREF, COMMUNITIES and LARGE_COMMUNITY
+func fixtureCommonAttributes(r *rand.Rand) []byte {
+	var b []byte
+	b = append(b, fixtureAttribute(0x40, 1, []byte{byte(r.IntN(3))})...)
+
+	pathLength := 1 + r.IntN(8)
+	path := []byte{2, byte(pathLength)}
+	for range pathLength {
+		path = binary.BigEndian.AppendUint32(path, 64496+uint32(r.IntN(400000)))
+	}
+	b = append(b, fixtureAttribute(0x40, 2, path)...)
+
+	if r.IntN(2) == 0 {
+		b = append(b, fixtureAttribute(0x80, 4, binary.BigEndian.AppendUint32(nil, uint32(r.IntN(1000))))...)
+	}
+	b = append(b, fixtureAttribute(0x40, 5, binary.BigEndian.AppendUint32(nil, 100))...)
+
+	if n := r.IntN(6); n != 0 {
+		var communities []byte
+		for range n {
+			communities = binary.BigEndian.AppendUint32(communities, r.Uint32())
+		}
+		b = append(b, fixtureAttribute(0xc0, 8, communities)...)
+	}
+	if n := r.IntN(3); n != 0 {
+		var communities []byte
+		for range n * 3 {
+			communities = binary.BigEndian.AppendUint32(communities, r.Uint32())
+		}
+		b = append(b, fixtureAttribute(0xc0, 32, communities)...)
+	}
+	return b
+}
+
+func fixtureIPv4Update(r *rand.Rand, i int) []byte {
+	attributes := fixtureCommonAttributes(r)
+	attributes = append(attributes, fixtureAttribute(0x40, 3, []byte{192, 0, 2, byte(1 + r.IntN(254))})...)
+
+	var nlri []byte
+	for j := range 1 + r.IntN(40) {
+		nlri = binary.BigEndian.AppendUint32(nlri, uint32(1+r.IntN(4)))
+		bits := 16 + r.IntN(9)
+		addr := binary.BigEndian.AppendUint32(nil, uint32(i*64+j)<<8)
+		nlri = append(nlri, byte(bits))
+		nlri = append(nlri, addr[:(bits+7)/8]...)
+	}
+
+	b := []byte{0, 0}
+	b = binary.BigEndian.AppendUint16(b, uint16(len(attributes)))
+	b = append(b, attributes...)
+	return append(b, nlri...)
+}
+
+func fixtureIPv6Update(r *rand.Rand, i int) []byte {
+	mpReach := []byte{0, 2, 1, 32}
+	mpReach = append(mpReach, 0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, byte(1+r.IntN(254)))
+	mpReach = append(mpReach, 0xfe, 0x80, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, byte(1+r.IntN(254)))
+	mpReach = append(mpReach, 0)
+	for j := range 1 + r.IntN(20) {
+		mpReach = binary.BigEndian.AppendUint32(mpReach, uint32(1+r.IntN(4)))
+		bits := 32 + r.IntN(17)
+		addr := []byte{0x2a, byte(r.IntN(16)), byte(i >> 8), byte(i), byte(j), 0}
+		mpReach = append(mpReach, byte(bits))
+		mpReach = append(mpReach, addr[:(bits+7)/8]...)
+	}
+
+	var attributes []byte
+	attributes = append(attributes, 0x90, 14)
+	attributes = binary.BigEndian.AppendUint16(attributes, uint16(len(mpReach)))
+	attributes = append(attributes, mpReach...)
+	attributes = append(attributes, fixtureCommonAttributes(r)...)
+
+	b := []byte{0, 0}
+	b = binary.BigEndian.AppendUint16(b, uint16(len(attributes)))
+	return append(b, attributes...)
+}
+
+func BenchmarkDecodeMsgUpdate(b *testing.B) {
+	messages := fixtureUpdates()
+	total := 0
+	for _, m := range messages {
+		total += len(m)
+	}
+	session := &common.LocalSession{
+		DefaultAFI:        common.AFI4,
+		AddPathEnabled:    true,
+		MultiProtocolAFIs: []common.AFI{common.AFI4, common.AFI6},
+	}
+
+	b.SetBytes(int64(total))
+	b.ReportAllocs()
+	for b.Loop() {
+		for _, m := range messages {
+			msg, err := DecodeMsgUpdate(m, session.DefaultAFI, session.AddPathEnabled)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if errs := msg.Validate(session); len(errs) != 0 {
+				b.Fatal(errs[0])
+			}
+		}
+	}
+	b.ReportMetric(float64(len(messages)), "msgs/op")
+}
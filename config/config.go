@@ -1,6 +1,10 @@
 package config
 
-import "net/netip"
+import (
+	"FlapAlerted/bgp/common"
+	"net/netip"
+	"time"
+)
 
 var GlobalConf UserConfig
 
@@ -10,11 +14,29 @@ type UserConfig struct {
 	UnderThresholdTarget     int
 	ExpiryRouteChangeCounter int
 	Asn                      uint32
-	ImportLimit              uint32
+	ImportLimit              uint32 // Maximum number of routes per session, zero if unlimited
+	ImportLimitWarning       int    // Percentage of the import limit above which modules are warned, zero if disabled
+	ImportLimitRestart       time.Duration
 	MaxPathHistory           int
 	MaxActivePrefixes        int
 	UseAddPath               bool
 	Debug                    bool
 	RouterID                 netip.Addr
 	BgpListenAddress         string
+	BgpMinTTL                int
+	BgpShutdownMessage       string
+	BmpListenAddress         string
+	Neighbors                []Neighbor
+	MrtReplayFiles           []string
+	MrtReplaySpeed           float64
+	MrtRecordDirectory       string
+	MrtRecordRotation        time.Duration
+	// Communities attached to the active flaps announced to the neighbors, announcing is disabled if both are empty
+	AnnounceCommunities      []uint32
+	AnnounceLargeCommunities []common.LargeCommunity
+}
+
+// AnnounceEnabled reports whether the active flaps are announced to the neighbors
+func (c *UserConfig) AnnounceEnabled() bool {
+	return len(c.AnnounceCommunities) != 0 || len(c.AnnounceLargeCommunities) != 0
 }
@@ -0,0 +1,279 @@
+package config
+
+import (
+	"FlapAlerted/bgp/common"
+	"fmt"
+	"math"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+type Neighbor struct {
+	// Address is either a single host or a range of addresses that passive connections are matched against
+	Address netip.Prefix
+	// RemoteAsn is the expected ASN of the neighbor. Zero means the own ASN (iBGP).
+	RemoteAsn    uint32
+	AnyRemoteAsn bool
+	// Active neighbors are connected to by the program. Only possible for single host addresses.
+	Active       bool
+	Port         uint16
+	ConnectRetry time.Duration
+	IdleHoldTime time.Duration
+
+	// Overrides of global settings. Zero or nil values use the global setting.
+	// ImportLimit is the maximum number of routes, zero if unlimited
+	ImportLimit *uint32
+	// Percentage of the import limit above which modules are warned
+	ImportLimitWarning *int
+	// Time during which connections are refused after the import limit has been exceeded
+	ImportLimitRestart *time.Duration
+	AddPath            *bool
+	HoldTime           *int
+	Hostname           string
+	Description        string
+	// MinTTL is the minimum TTL of received packets (RFC 5082). Zero disables the check.
+	MinTTL *int
+
+	// Password is the TCP-MD5 key (RFC 2385) of the session. Empty if not used.
+	Password string
+
+	// Role is the own BGP Role (RFC 9234) advertised to the neighbor, nil if not advertised
+	Role *common.Role
+
+	// Announce enables or disables announcing the active flaps to the neighbor, nil uses the global setting
+	Announce *bool
+}
+
+// Maximum length of a TCP-MD5 key supported by Linux
+const maxPasswordLength = 80
+
+const (
+	defaultNeighborPort         = 179
+	defaultNeighborConnectRetry = 30 * time.Second
+	defaultNeighborIdleHoldTime = 10 * time.Second
+)
+
+// ParseNeighbor parses a neighbor definition consisting of comma-separated key=value pairs,
+// for example "address=192.0.2.1,asn=64500,port=179,connectRetry=30s". See splitOptions for quoted values.
+func ParseNeighbor(s string) (Neighbor, error) {
+	n := Neighbor{
+		Port:         defaultNeighborPort,
+		ConnectRetry: defaultNeighborConnectRetry,
+		IdleHoldTime: defaultNeighborIdleHoldTime,
+	}
+	passive := false
+
+	options, err := splitOptions(s)
+	if err != nil {
+		return n, err
+	}
+	for _, option := range options {
+		key, value := option.key, option.value
+		var err error
+		switch key {
+		case "address":
+			n.Address, err = parseAddressOrPrefix(value)
+		case "asn":
+			if value == "any" {
+				n.AnyRemoteAsn = true
+				break
+			}
+			var asn uint64
+			asn, err = strconv.ParseUint(value, 10, 32)
+			n.RemoteAsn = uint32(asn)
+		case "passive":
+			passive, err = strconv.ParseBool(value)
+		case "port":
+			var port uint64
+			port, err = strconv.ParseUint(value, 10, 16)
+			n.Port = uint16(port)
+		case "connectRetry":
+			n.ConnectRetry, err = parsePositiveDuration(value)
+		case "idleHold":
+			n.IdleHoldTime, err = parsePositiveDuration(value)
+		case "importLimitThousands":
+			var limit uint64
+			limit, err = strconv.ParseUint(value, 10, 32)
+			if err == nil && limit*1000 > math.MaxUint32 {
+				err = fmt.Errorf("value too large")
+			}
+			l := uint32(limit * 1000)
+			n.ImportLimit = &l
+		case "importLimitWarning":
+			var percentage uint64
+			percentage, err = strconv.ParseUint(value, 10, 8)
+			if err == nil && percentage > 100 {
+				err = fmt.Errorf("percentage must not be larger than 100")
+			}
+			p := int(percentage)
+			n.ImportLimitWarning = &p
+		case "importLimitRestart":
+			var restart time.Duration
+			restart, err = time.ParseDuration(value)
+			if err == nil && restart < 0 {
+				err = fmt.Errorf("duration must not be negative")
+			}
+			n.ImportLimitRestart = &restart
+		case "addPath":
+			var addPath bool
+			addPath, err = strconv.ParseBool(value)
+			n.AddPath = &addPath
+		case "holdTime":
+			var holdTime uint64
+			holdTime, err = strconv.ParseUint(value, 10, 16)
+			if err == nil && (holdTime == 1 || holdTime == 2) {
+				err = fmt.Errorf("hold time must be zero or at least three seconds")
+			}
+			h := int(holdTime)
+			n.HoldTime = &h
+		case "hostname":
+			if len(value) > 255 {
+				err = fmt.Errorf("hostname too long")
+			}
+			n.Hostname = value
+		case "description":
+			n.Description = value
+		case "minTTL":
+			var minTTL uint64
+			minTTL, err = strconv.ParseUint(value, 10, 8)
+			t := int(minTTL)
+			n.MinTTL = &t
+		case "password":
+			if len(value) > maxPasswordLength {
+				err = fmt.Errorf("password too long")
+			}
+			n.Password = value
+		case "role":
+			var role common.Role
+			role, err = common.ParseRole(value)
+			n.Role = &role
+		case "announce":
+			var announce bool
+			announce, err = strconv.ParseBool(value)
+			n.Announce = &announce
+		default:
+			return n, fmt.Errorf("unknown neighbor option %q", key)
+		}
+		if err != nil {
+			return n, fmt.Errorf("invalid value for neighbor option %q: %w", key, err)
+		}
+	}
+
+	if !n.Address.IsValid() {
+		return n, fmt.Errorf("neighbor address not specified")
+	}
+	n.Active = n.Address.IsSingleIP() && !passive
+	return n, nil
+}
+
+type option struct {
+	key   string
+	value string
+}
+
+// splitOptions splits comma-separated key=value pairs. Spaces around keys and values are ignored.
+// Values enclosed in double quotes are used as given and may contain commas and leading or trailing spaces,
+// a backslash escapes a double quote or backslash within them.
+func splitOptions(s string) ([]option, error) {
+	var options []option
+	for {
+		s = strings.TrimLeftFunc(s, unicode.IsSpace)
+		if s == "" {
+			return options, nil
+		}
+		if s[0] == ',' {
+			s = s[1:]
+			continue
+		}
+		item, rest, _ := strings.Cut(s, ",")
+		key, _, found := strings.Cut(item, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid neighbor option %q: expected key=value", item)
+		}
+		s = strings.TrimLeftFunc(s[len(key)+1:], unicode.IsSpace)
+		key = strings.TrimSpace(key)
+
+		if !strings.HasPrefix(s, `"`) {
+			value, _, _ := strings.Cut(s, ",")
+			options = append(options, option{key: key, value: strings.TrimSpace(value)})
+			s = rest
+			continue
+		}
+
+		var value strings.Builder
+		closed := false
+		i := 1
+		for ; i < len(s) && !closed; i++ {
+			switch s[i] {
+			case '\\':
+				i++
+				if i == len(s) || (s[i] != '"' && s[i] != '\\') {
+					return nil, fmt.Errorf("invalid escape sequence in value of neighbor option %q", key)
+				}
+				value.WriteByte(s[i])
+			case '"':
+				closed = true
+			default:
+				value.WriteByte(s[i])
+			}
+		}
+		if !closed {
+			return nil, fmt.Errorf("unterminated quoted value of neighbor option %q", key)
+		}
+		s = strings.TrimLeftFunc(s[i:], unicode.IsSpace)
+		if s != "" && s[0] != ',' {
+			return nil, fmt.Errorf("unexpected characters after quoted value of neighbor option %q", key)
+		}
+		options = append(options, option{key: key, value: value.String()})
+	}
+}
+
+func parseAddressOrPrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		if p.Addr().Is4In6() && p.Bits() >= 96 {
+			p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+		}
+		return p.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func parsePositiveDuration(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("duration must be positive")
+	}
+	return d, nil
+}
+
+// FindNeighbor returns the most specific configured neighbor matching a remote address
+func (c *UserConfig) FindNeighbor(addr netip.Addr) (Neighbor, bool) {
+	addr = addr.Unmap()
+	var best Neighbor
+	found := false
+	for _, n := range c.Neighbors {
+		if !n.Address.Contains(addr) {
+			continue
+		}
+		if !found || n.Address.Bits() > best.Address.Bits() {
+			best = n
+			found = true
+		}
+	}
+	return best, found
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"FlapAlerted/bgp/common"
+	"FlapAlerted/bgp/notification"
 	"FlapAlerted/config"
 	_ "FlapAlerted/modules"
 	"FlapAlerted/monitor"
@@ -36,10 +38,63 @@ func main() {
 		maxActivePrefixes        = flag.Uint("maxActivePrefixes", 5000, "Maximum number of active prefixes. Advanced setting, changing not recommended")
 		disableAddPath           = flag.Bool("disableAddPath", false, "Disable BGP AddPath support. (Setting must be replicated in BGP daemon)")
 		bgpListenAddress         = flag.String("bgpListenAddress", ":1790", "Address to listen on for incoming BGP connections")
+		bgpMinTTL                = flag.Uint("bgpMinTTL", 0, "Minimum TTL of packets received on BGP sessions (RFC 5082), '255' for directly connected neighbors. Use '0' to disable.")
+		bgpShutdownMessage       = flag.String("bgpShutdownMessage", "", "Message sent to BGP neighbors when sessions are shut down by the program (RFC 9003), at most 255 bytes")
+		bmpListenAddress         = flag.String("bmpListenAddress", "", "Address to listen on for incoming BMP connections (disabled if empty)")
 		enableDebug              = flag.Bool("debug", false, "Enable debug mode (produces a lot of output)")
-		importLimitThousands     = flag.Uint("importLimitThousands", 10000, "Maximum number of allowed routes per session in thousands")
+		importLimitThousands     = flag.Uint("importLimitThousands", 10000, "Maximum number of allowed routes per session in thousands. Use '0' to disable.")
+		importLimitWarning       = flag.Uint("importLimitWarning", 80, "Percentage of the import limit of a session above which modules are warned. Use '0' to disable.")
+		importLimitRestart       = flag.Duration("importLimitRestart", 0, "Time during which connections from a neighbor are refused after it exceeded the import limit. Use '0' to allow immediate reconnects.")
+		mrtRecordDirectory       = flag.String("mrtRecordDirectory", "", "Directory to record the updates received by BGP sessions to as MRT files (disabled if empty)")
+		mrtRecordRotation        = flag.Duration("mrtRecordRotation", 15*time.Minute, "Time after which a new MRT record file is started")
+		mrtReplaySpeed           = flag.Float64("mrtReplaySpeed", 1, "Speed factor of the MRT replay relative to real time. Use '0' to replay as fast as possible.")
 	)
 
+	var neighbors []config.Neighbor
+	flag.Func("bgpNeighbor", "BGP neighbor given as comma-separated key=value pairs, values containing commas can be double-quoted "+
+		"(address, asn, passive, port, connectRetry, idleHold, importLimitThousands, importLimitWarning, importLimitRestart, addPath, holdTime, hostname, description, minTTL, password, role, announce); "+
+		"can be specified multiple times", func(s string) error {
+		n, err := config.ParseNeighbor(s)
+		if err != nil {
+			return err
+		}
+		neighbors = append(neighbors, n)
+		return nil
+	})
+
+	var mrtReplayFiles []string
+	flag.Func("mrtReplay", "MRT file (BGP4MP or TABLE_DUMP_V2, optionally gzip or bzip2 compressed) to replay instead of accepting BGP sessions; "+
+		"can be specified multiple times, files are replayed in the given order", func(s string) error {
+		mrtReplayFiles = append(mrtReplayFiles, s)
+		return nil
+	})
+
+	var announceCommunities []uint32
+	flag.Func("announceCommunity", "Community (asn:value) attached to the active flaps announced to the neighbors; "+
+		"can be specified multiple times or as a comma-separated list, announcing is disabled unless a community or large community is set", func(s string) error {
+		for item := range strings.SplitSeq(s, ",") {
+			c, err := common.ParseCommunity(strings.TrimSpace(item))
+			if err != nil {
+				return err
+			}
+			announceCommunities = append(announceCommunities, c)
+		}
+		return nil
+	})
+
+	var announceLargeCommunities []common.LargeCommunity
+	flag.Func("announceLargeCommunity", "Large community (asn:value:value) attached to the active flaps announced to the neighbors; "+
+		"can be specified multiple times or as a comma-separated list", func(s string) error {
+		for item := range strings.SplitSeq(s, ",") {
+			c, err := common.ParseLargeCommunity(strings.TrimSpace(item))
+			if err != nil {
+				return err
+			}
+			announceLargeCommunities = append(announceLargeCommunities, c)
+		}
+		return nil
+	})
+
 	flag.Parse()
 
 	// Support environment variables
@@ -69,7 +124,19 @@ func main() {
 	conf.UseAddPath = !*disableAddPath
 	conf.Debug = *enableDebug
 	conf.BgpListenAddress = *bgpListenAddress
+	conf.BgpMinTTL = int(*bgpMinTTL)
+	conf.BgpShutdownMessage = *bgpShutdownMessage
+	conf.BmpListenAddress = *bmpListenAddress
 	conf.ImportLimit = uint32(*importLimitThousands * 1000)
+	conf.ImportLimitWarning = int(*importLimitWarning)
+	conf.ImportLimitRestart = *importLimitRestart
+	conf.Neighbors = neighbors
+	conf.MrtReplayFiles = mrtReplayFiles
+	conf.MrtReplaySpeed = *mrtReplaySpeed
+	conf.MrtRecordDirectory = *mrtRecordDirectory
+	conf.MrtRecordRotation = *mrtRecordRotation
+	conf.AnnounceCommunities = announceCommunities
+	conf.AnnounceLargeCommunities = announceLargeCommunities
 
 	if conf.Asn == 0 {
 		fmt.Println("ASN value not specified. Use '-h' to view available options.")
@@ -87,6 +154,36 @@ func main() {
 		conf.ExpiryRouteChangeCounter = conf.RouteChangeCounter
 	}
 
+	if conf.BgpMinTTL > 255 {
+		fmt.Println("BGP minimum TTL must not be larger than 255")
+		os.Exit(1)
+	}
+
+	if conf.ImportLimitWarning > 100 {
+		fmt.Println("Import limit warning percentage must not be larger than 100")
+		os.Exit(1)
+	}
+
+	if conf.ImportLimitRestart < 0 {
+		fmt.Println("Import limit restart time must not be negative")
+		os.Exit(1)
+	}
+
+	if len(conf.BgpShutdownMessage) > notification.MaxShutdownCommunicationLength {
+		fmt.Println("BGP shutdown message must not be longer than", notification.MaxShutdownCommunicationLength, "bytes")
+		os.Exit(1)
+	}
+
+	if conf.MrtRecordRotation <= 0 {
+		fmt.Println("MRT record rotation must be positive")
+		os.Exit(1)
+	}
+
+	if conf.MrtReplaySpeed < 0 {
+		fmt.Println("MRT replay speed must not be negative")
+		os.Exit(1)
+	}
+
 	var err error
 	conf.RouterID, err = netip.ParseAddr(*routerID)
 	if err != nil {
@@ -53,6 +53,21 @@ func processCommand(command string, cancel context.CancelFunc, logger *slog.Logg
 		if len(args) == 0 || args[0] != "false" {
 			cancel()
 		}
+	case "ROUTES":
+		if len(args) == 0 || len(args) > 2 {
+			err = errors.New("expected a prefix or an address and an optional match type")
+			return
+		}
+		match := ""
+		if len(args) == 2 {
+			match = args[1]
+		}
+		var result monitor.LookupResult
+		result, err = monitor.LookupRoutes(args[0], match)
+		if err != nil {
+			return
+		}
+		response, err = toJSON(result)
 	case "INSTANCE":
 		response = *collectorInstanceName
 	case "VERSION":
@@ -0,0 +1,95 @@
+package httpAPI
+
+import (
+	"FlapAlerted/monitor"
+	"encoding/json"
+	"net/http"
+	"net/netip"
+	"time"
+)
+
+func getAdminSessions(w http.ResponseWriter, _ *http.Request) {
+	result := struct {
+		Sessions []monitor.SessionInfo
+		Blocked  []monitor.SessionBlock
+	}{
+		Sessions: monitor.GetSessions(),
+		Blocked:  monitor.GetBlockedRemotes(),
+	}
+	b, err := json.Marshal(result)
+	if err != nil {
+		logger.Warn("Failed to marshal sessions to JSON", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	_, _ = w.Write(b)
+}
+
+// resetSession closes the sessions with the remote with an Administrative Reset and optionally blocks it from reconnecting
+func resetSession(w http.ResponseWriter, r *http.Request) {
+	remote, err := netip.ParseAddr(r.FormValue("remote"))
+	if err != nil {
+		http.Error(w, "Invalid remote address", http.StatusBadRequest)
+		return
+	}
+	var block time.Duration
+	if s := r.FormValue("block"); s != "" {
+		block, err = time.ParseDuration(s)
+		if err != nil || block <= 0 {
+			http.Error(w, "Invalid block duration", http.StatusBadRequest)
+			return
+		}
+	}
+	message := r.FormValue("message")
+	if len(message) > monitor.MaxShutdownCommunicationLength {
+		http.Error(w, "Message too long", http.StatusBadRequest)
+		return
+	}
+
+	result := struct {
+		Reset int
+		Block *monitor.SessionBlock `json:",omitempty"`
+	}{}
+	if block != 0 {
+		// Block first so that the remote cannot reconnect in between
+		b := monitor.BlockRemote(remote, block)
+		result.Block = &b
+	}
+	result.Reset = monitor.ResetSessions(remote, message)
+	logger.Info("Administrative session reset", "remote", remote, "sessions", result.Reset, "block", block)
+	if result.Reset == 0 && result.Block == nil {
+		http.Error(w, "No session with this remote", http.StatusNotFound)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+func blockRemote(w http.ResponseWriter, r *http.Request) {
+	remote, err := netip.ParseAddr(r.FormValue("remote"))
+	if err != nil {
+		http.Error(w, "Invalid remote address", http.StatusBadRequest)
+		return
+	}
+	duration, err := time.ParseDuration(r.FormValue("duration"))
+	if err != nil || duration <= 0 {
+		http.Error(w, "Invalid block duration", http.StatusBadRequest)
+		return
+	}
+	block := monitor.BlockRemote(remote, duration)
+	logger.Info("Blocked remote", "remote", remote, "duration", duration)
+	_ = json.NewEncoder(w).Encode(block)
+}
+
+func unblockRemote(w http.ResponseWriter, r *http.Request) {
+	remote, err := netip.ParseAddr(r.FormValue("remote"))
+	if err != nil {
+		http.Error(w, "Invalid remote address", http.StatusBadRequest)
+		return
+	}
+	if !monitor.UnblockRemote(remote) {
+		http.Error(w, "Remote is not blocked", http.StatusNotFound)
+		return
+	}
+	logger.Info("Unblocked remote", "remote", remote)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -26,7 +26,7 @@ var dashboardContent embed.FS
 
 var (
 	limitedHttpAPI         = flag.Bool("httpAPILimit", false, "Disable http API endpoints not needed for the user interface and activate basic scraping protection")
-	apiKey                 = flag.String("httpAPIKey", "", "API key to access limited endpoints, when 'limitedHttpApi' is set. Empty to disable")
+	apiKey                 = flag.String("httpAPIKey", "", "API key to access limited endpoints, when 'limitedHttpApi' is set, and the administrative endpoints. Empty to disable")
 	httpAPIListenAddress   = flag.String("httpAPIListenAddress", ":8699", "Listen address for the HTTP API (TCP address like :8699 or Unix socket path)")
 	gageMaxValue           = flag.Uint("httpGageMaxValue", 400, "HTTP dashboard Gage max value")
 	gageDisableDynamic     = flag.Bool("httpGageDisableDynamic", false, "Disable dynamic Gage max value based on session count")
@@ -67,6 +67,7 @@ func startComplete() {
 	mux.HandleFunc("/peers/asn", antiScrapeMiddleware(getPeer))
 	mux.HandleFunc("/flaps/statStream", getStatisticStream)
 	mux.HandleFunc("/sessions", antiScrapeMiddleware(getBgpSessions))
+	mux.HandleFunc("/sessions/history", antiScrapeMiddleware(getBgpSessionHistory))
 
 	mux.HandleFunc("/flaps/historical/prefix", antiScrapeMiddleware(getHistoricalPrefix))
 	mux.HandleFunc("/flaps/historical/list", antiScrapeMiddleware(getHistoricalList))
@@ -79,12 +80,25 @@ func startComplete() {
 	// --- Secondary endpoints ---
 	mux.HandleFunc("/capabilities", requireAPIKeyWhenLimited(getCapabilities))
 	mux.HandleFunc("/peers/active", requireAPIKeyWhenLimited(getActivePeers))
+	mux.HandleFunc("/peers/sessions", requireAPIKeyWhenLimited(getSessionRates))
 	mux.HandleFunc("/flaps/avgRouteChanges90", requireAPIKeyWhenLimited(getAvgRouteChanges))
 	mux.HandleFunc("/flaps/active/compact", requireAPIKeyWhenLimited(getActiveFlaps))
 	mux.HandleFunc("/flaps/active/roa", requireAPIKeyWhenLimited(getActiveFlapsRoa))
+	mux.HandleFunc("/flaps/active/within", requireAPIKeyWhenLimited(getActiveFlapsWithin))
+	mux.HandleFunc("/routes", requireAPIKeyWhenLimited(getRoutes))
 	mux.HandleFunc("/flaps/metrics/json", requireAPIKeyWhenLimited(metrics))
 	mux.HandleFunc("/flaps/metrics/prometheus", requireAPIKeyWhenLimited(prometheus))
 	mux.HandleFunc("/flaps/metrics/prometheus/activePeerRates", requireAPIKeyWhenLimited(prometheusActivePeerRates))
+	mux.HandleFunc("/flaps/metrics/prometheus/sessions", requireAPIKeyWhenLimited(prometheusSessions))
+
+	// --- Administrative endpoints ---
+	// Only available with an API key, regardless of whether the API is limited
+	if *apiKey != "" {
+		mux.HandleFunc("GET /admin/sessions", requireAPIKey(getAdminSessions))
+		mux.HandleFunc("POST /admin/sessions/reset", requireAPIKey(resetSession))
+		mux.HandleFunc("POST /admin/sessions/block", requireAPIKey(blockRemote))
+		mux.HandleFunc("POST /admin/sessions/unblock", requireAPIKey(unblockRemote))
+	}
 
 	s := &http.Server{
 		ReadHeaderTimeout: 10 * time.Second,
@@ -114,11 +128,19 @@ func startComplete() {
 }
 
 func requireAPIKeyWhenLimited(next http.HandlerFunc) http.HandlerFunc {
+	limited := requireAPIKey(next)
 	return func(w http.ResponseWriter, r *http.Request) {
 		if !*limitedHttpAPI {
 			next(w, r)
 			return
 		}
+		limited(w, r)
+	}
+}
+
+// requireAPIKey rejects requests without the API key in the X-API-Key header, and all requests if no key is configured
+func requireAPIKey(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
 		if *apiKey == "" {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
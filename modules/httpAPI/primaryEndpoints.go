@@ -165,3 +165,12 @@ func getBgpSessions(w http.ResponseWriter, _ *http.Request) {
 	}
 	_, _ = w.Write([]byte(info))
 }
+
+func getBgpSessionHistory(w http.ResponseWriter, _ *http.Request) {
+	history, err := monitor.GetSessionHistoryJson()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	_, _ = w.Write([]byte(history))
+}
@@ -6,7 +6,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/netip"
 	"strconv"
+	"strings"
 )
 
 func getActiveFlaps(w http.ResponseWriter, _ *http.Request) {
@@ -33,6 +35,16 @@ func getActivePeers(w http.ResponseWriter, _ *http.Request) {
 	_, _ = w.Write(b)
 }
 
+func getSessionRates(w http.ResponseWriter, _ *http.Request) {
+	b, err := json.Marshal(analyze.GetSessionRates())
+	if err != nil {
+		logger.Warn("Failed to marshal list to JSON", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	_, _ = w.Write(b)
+}
+
 func getCapabilities(w http.ResponseWriter, _ *http.Request) {
 	b, err := json.Marshal(monitor.GetCapabilities())
 	if err != nil {
@@ -49,6 +61,38 @@ func getAvgRouteChanges(w http.ResponseWriter, _ *http.Request) {
 	_, _ = w.Write([]byte(avgStr))
 }
 
+// getActiveFlapsWithin returns the active flaps of a prefix and its more-specifics
+func getActiveFlapsWithin(w http.ResponseWriter, r *http.Request) {
+	prefix, err := netip.ParsePrefix(r.URL.Query().Get("prefix"))
+	if err != nil {
+		http.Error(w, "Invalid prefix", http.StatusBadRequest)
+		return
+	}
+	b, err := json.Marshal(monitor.GetActiveFlapsWithin(prefix))
+	if err != nil {
+		logger.Warn("Failed to marshal list to JSON", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	_, _ = w.Write(b)
+}
+
+// getRoutes returns the current routes of all sessions for a prefix or an address
+func getRoutes(w http.ResponseWriter, r *http.Request) {
+	result, err := monitor.LookupRoutes(r.URL.Query().Get("prefix"), r.URL.Query().Get("match"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := json.Marshal(result)
+	if err != nil {
+		logger.Warn("Failed to marshal routes to JSON", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	_, _ = w.Write(b)
+}
+
 func metrics(w http.ResponseWriter, _ *http.Request) {
 	b, err := json.Marshal(monitor.GetMetric())
 	if err != nil {
@@ -81,6 +125,22 @@ func prometheus(w http.ResponseWriter, _ *http.Request) {
 	_, _ = fmt.Fprintln(w, "# HELP sessions Number of connected BGP feeds")
 	_, _ = fmt.Fprintln(w, "# TYPE sessions gauge")
 	_, _ = fmt.Fprintln(w, "sessions", metric.Sessions)
+
+	_, _ = fmt.Fprintln(w, "# HELP interned_as_paths Number of distinct AS paths held in memory")
+	_, _ = fmt.Fprintln(w, "# TYPE interned_as_paths gauge")
+	_, _ = fmt.Fprintln(w, "interned_as_paths", metric.InternedAsPaths)
+
+	_, _ = fmt.Fprintln(w, "# HELP interned_as_path_bytes Memory used by distinct AS paths in bytes")
+	_, _ = fmt.Fprintln(w, "# TYPE interned_as_path_bytes gauge")
+	_, _ = fmt.Fprintln(w, "interned_as_path_bytes", metric.InternedAsPathBytes)
+
+	_, _ = fmt.Fprintln(w, "# HELP as_path_intern_lookups_total Number of received AS paths looked up in the AS path pool")
+	_, _ = fmt.Fprintln(w, "# TYPE as_path_intern_lookups_total counter")
+	_, _ = fmt.Fprintln(w, "as_path_intern_lookups_total", metric.AsPathInternLookups)
+
+	_, _ = fmt.Fprintln(w, "# HELP as_path_intern_hits_total Number of received AS paths that were already held in memory")
+	_, _ = fmt.Fprintln(w, "# TYPE as_path_intern_hits_total counter")
+	_, _ = fmt.Fprintln(w, "as_path_intern_hits_total", metric.AsPathInternHits)
 }
 
 func prometheusActivePeerRates(w http.ResponseWriter, _ *http.Request) {
@@ -107,4 +167,88 @@ func prometheusActivePeerRates(w http.ResponseWriter, _ *http.Request) {
 			return
 		}
 	}
+
+	sessionRates := analyze.GetSessionRates()
+
+	_, _ = fmt.Fprintln(w, "# HELP bgp_session_updates_per_second BGP update rate per second for each session")
+	_, _ = fmt.Fprintln(w, "# TYPE bgp_session_updates_per_second gauge")
+
+	_, _ = fmt.Fprintln(w, "# HELP bgp_session_updates_avg_per_second BGP update rate per second for each session (averaged)")
+	_, _ = fmt.Fprintln(w, "# TYPE bgp_session_updates_avg_per_second gauge")
+
+	for _, r := range sessionRates {
+		labels := sessionIDLabels(r.Session)
+		if _, err := fmt.Fprintf(w, "bgp_session_updates_per_second{%s} %d\n", labels, r.RateSec); err != nil {
+			return
+		}
+		if _, err := fmt.Fprintf(w, "bgp_session_updates_avg_per_second{%s} %.6f\n", labels, r.RateSecAvg); err != nil {
+			return
+		}
+	}
+}
+
+func prometheusSessions(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+
+	sessions := monitor.GetSessions()
+
+	type sessionMetric struct {
+		name, help string
+		value      func(s monitor.SessionInfo) uint64
+	}
+	sessionMetrics := []sessionMetric{
+		{"bgp_session_established_timestamp_seconds", "Time the session was established", func(s monitor.SessionInfo) uint64 { return uint64(s.EstablishTime) }},
+		{"bgp_session_imported_routes", "Number of routes imported by the session", func(s monitor.SessionInfo) uint64 { return uint64(s.ImportCount) }},
+		{"bgp_session_received_bytes_total", "Bytes of BGP messages received", func(s monitor.SessionInfo) uint64 { return s.Stats.ReceivedBytes }},
+		{"bgp_session_announced_nlri_total", "Prefixes announced by the peer", func(s monitor.SessionInfo) uint64 { return s.Stats.AnnouncedNLRI }},
+		{"bgp_session_withdrawn_nlri_total", "Prefixes withdrawn by the peer", func(s monitor.SessionInfo) uint64 { return s.Stats.WithdrawnNLRI }},
+		{"bgp_session_path_changes_total", "Path changes caused by the session", func(s monitor.SessionInfo) uint64 { return s.Stats.PathChanges }},
+	}
+	for _, m := range sessionMetrics {
+		_, _ = fmt.Fprintln(w, "# HELP", m.name, m.help)
+		_, _ = fmt.Fprintln(w, "# TYPE", m.name, metricType(m.name))
+		for _, s := range sessions {
+			if _, err := fmt.Fprintf(w, "%s{%s} %d\n", m.name, sessionLabels(s), m.value(s)); err != nil {
+				return
+			}
+		}
+	}
+
+	_, _ = fmt.Fprintln(w, "# HELP bgp_session_received_messages_total BGP messages received by type")
+	_, _ = fmt.Fprintln(w, "# TYPE bgp_session_received_messages_total counter")
+	for _, s := range sessions {
+		labels := sessionLabels(s)
+		for _, c := range []struct {
+			msgType string
+			count   uint64
+		}{
+			{"open", s.Stats.Messages.Open},
+			{"update", s.Stats.Messages.Update},
+			{"notification", s.Stats.Messages.Notification},
+			{"keepalive", s.Stats.Messages.KeepAlive},
+		} {
+			if _, err := fmt.Fprintf(w, "bgp_session_received_messages_total{%s,type=%q} %d\n", labels, c.msgType, c.count); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func sessionLabels(s monitor.SessionInfo) string {
+	return fmt.Sprintf("remote=%q,bmp_router=%q,asn=\"%d\"", s.RemoteAddress.String(), s.BMPRouter, s.ASN)
+}
+
+func sessionIDLabels(id monitor.SessionID) string {
+	bmpRouter := ""
+	if id.BMPRouter.IsValid() {
+		bmpRouter = id.BMPRouter.String()
+	}
+	return fmt.Sprintf("remote=%q,router_id=%q,bmp_router=%q", id.Remote.String(), id.RouterID.String(), bmpRouter)
+}
+
+func metricType(name string) string {
+	if strings.HasSuffix(name, "_total") {
+		return "counter"
+	}
+	return "gauge"
 }
@@ -32,7 +32,31 @@ func (m *Module) OnEvent(f analyze.FlapEvent, isStart bool) {
 	if isStart {
 		eventType = "start"
 	}
-	m.logger.Info("event", "type", eventType, "prefix", f.Prefix.String(), "first_seen", f.FirstSeen, "total_path_changes", f.TotalPathChanges)
+	m.logger.Info("event", "type", eventType, "prefix", f.Prefix.String(), "first_seen", f.FirstSeen, "total_path_changes", f.TotalPathChanges, "sessions", len(f.Sessions), "established_sessions", f.EstablishedSessions, "session_resets", f.SessionResets)
+}
+
+func (m *Module) OnRouteLeak(f analyze.FlapEvent) {
+	m.logger.Warn("event", "type", "route_leak", "prefix", f.Prefix.String(), "first_seen", f.FirstSeen, "total_path_changes", f.TotalPathChanges, "route_leak_path_changes", f.PossibleRouteLeakChanges)
+}
+
+func (m *Module) OnSessionEvent(e monitor.SessionEvent) {
+	attrs := []any{"type", e.Type.String(), "remote", e.Session.Remote, "router_id", e.Session.RouterID, "hostname", e.Session.Hostname, "asn", e.Session.ASN}
+	if e.Session.BMPRouter != "" {
+		attrs = append(attrs, "bmp_router", e.Session.BMPRouter)
+	}
+	if e.Type == monitor.SessionEstablished {
+		m.logger.Info("session", attrs...)
+		return
+	}
+	if e.Close == nil {
+		attrs = append(attrs, "import_count", e.Session.ImportCount, "import_limit", e.Session.ImportLimit)
+	} else {
+		attrs = append(attrs, "reason", e.Close.Reason)
+		if e.Close.ShutdownCommunication != "" {
+			attrs = append(attrs, "shutdown_communication", e.Close.ShutdownCommunication)
+		}
+	}
+	m.logger.Warn("session", attrs...)
 }
 
 func init() {
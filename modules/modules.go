@@ -6,6 +6,7 @@ import (
 	_ "FlapAlerted/modules/httpAPI"
 	_ "FlapAlerted/modules/log"
 	_ "FlapAlerted/modules/roaFilter"
+	_ "FlapAlerted/modules/rtr"
 	_ "FlapAlerted/modules/script"
 	_ "FlapAlerted/modules/webhook"
 )
@@ -0,0 +1,167 @@
+//go:build !disable_mod_rtr
+
+package rtr
+
+import (
+	"math/rand/v2"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// Number of incremental updates kept to answer Serial Queries, older serials receive a Cache Reset
+	maxDeltas = 100
+	// Serial Notify PDUs are rate-limited to one per minute (RFC 8210 section 5.2)
+	minNotifyInterval = time.Minute
+)
+
+// delta are the changes from a serial to the next one
+type delta struct {
+	serial    uint32
+	announced []netip.Prefix
+	withdrawn []netip.Prefix
+}
+
+// cache holds the VRPs served to the routers
+type cache struct {
+	lock      sync.RWMutex
+	sessionID uint16
+	serial    uint32
+	prefixes  map[netip.Prefix]struct{}
+	// Oldest first
+	deltas  []delta
+	clients map[*client]struct{}
+
+	// Rate limiting of Serial Notify PDUs
+	lastNotify    time.Time
+	pendingNotify bool
+}
+
+func newCache() *cache {
+	return &cache{
+		// Changes on every start, so that routers discard the data of a previous instance
+		sessionID: uint16(rand.Uint32()),
+		prefixes:  make(map[netip.Prefix]struct{}),
+		clients:   make(map[*client]struct{}),
+	}
+}
+
+// update replaces the prefixes, starting a new serial if they have changed, and notifies the clients.
+// Returns true if the prefixes have changed.
+func (c *cache) update(prefixes []netip.Prefix, now time.Time) bool {
+	current := make(map[netip.Prefix]struct{}, len(prefixes))
+	for _, p := range prefixes {
+		current[p.Masked()] = struct{}{}
+	}
+
+	c.lock.Lock()
+	d := delta{serial: c.serial}
+	for p := range current {
+		if _, found := c.prefixes[p]; !found {
+			d.announced = append(d.announced, p)
+		}
+	}
+	for p := range c.prefixes {
+		if _, found := current[p]; !found {
+			d.withdrawn = append(d.withdrawn, p)
+		}
+	}
+	changed := len(d.announced) != 0 || len(d.withdrawn) != 0
+	if changed {
+		c.prefixes = current
+		c.serial++
+		c.deltas = append(c.deltas, d)
+		if len(c.deltas) > maxDeltas {
+			c.deltas = slices.Delete(c.deltas, 0, len(c.deltas)-maxDeltas)
+		}
+		c.pendingNotify = true
+	}
+	if c.pendingNotify && now.Sub(c.lastNotify) >= minNotifyInterval {
+		for cl := range c.clients {
+			cl.notify()
+		}
+		c.pendingNotify = false
+		c.lastNotify = now
+	}
+	c.lock.Unlock()
+	return changed
+}
+
+// current returns the session ID and serial of the cache
+func (c *cache) current() (sessionID uint16, serial uint32) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.sessionID, c.serial
+}
+
+// snapshot returns all prefixes, sorted, along with their serial
+func (c *cache) snapshot() (prefixes []netip.Prefix, serial uint32) {
+	c.lock.RLock()
+	prefixes = make([]netip.Prefix, 0, len(c.prefixes))
+	for p := range c.prefixes {
+		prefixes = append(prefixes, p)
+	}
+	serial = c.serial
+	c.lock.RUnlock()
+
+	slices.SortFunc(prefixes, netip.Prefix.Compare)
+	return prefixes, serial
+}
+
+// changesSince returns the prefixes announced and withdrawn since the serial, sorted, along with the current serial.
+// Returns false if the serial is unknown.
+func (c *cache) changesSince(since uint32) (announced, withdrawn []netip.Prefix, serial uint32, ok bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if since == c.serial {
+		return nil, nil, c.serial, true
+	}
+	start := slices.IndexFunc(c.deltas, func(d delta) bool {
+		return d.serial == since
+	})
+	if start == -1 {
+		return nil, nil, 0, false
+	}
+
+	// Whether a prefix was present at the queried serial follows from its first change
+	presentBefore := make(map[netip.Prefix]bool)
+	for _, d := range c.deltas[start:] {
+		for _, p := range d.announced {
+			if _, seen := presentBefore[p]; !seen {
+				presentBefore[p] = false
+			}
+		}
+		for _, p := range d.withdrawn {
+			if _, seen := presentBefore[p]; !seen {
+				presentBefore[p] = true
+			}
+		}
+	}
+	for p, before := range presentBefore {
+		_, present := c.prefixes[p]
+		switch {
+		case present && !before:
+			announced = append(announced, p)
+		case !present && before:
+			withdrawn = append(withdrawn, p)
+		}
+	}
+	slices.SortFunc(announced, netip.Prefix.Compare)
+	slices.SortFunc(withdrawn, netip.Prefix.Compare)
+	return announced, withdrawn, c.serial, true
+}
+
+func (c *cache) addClient(cl *client) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.clients[cl] = struct{}{}
+}
+
+// removeClient removes the client, which is not notified afterward
+func (c *cache) removeClient(cl *client) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.clients, cl)
+}
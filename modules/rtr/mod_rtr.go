@@ -0,0 +1,283 @@
+//go:build !disable_mod_rtr
+
+package rtr
+
+import (
+	"FlapAlerted/analyze"
+	"FlapAlerted/monitor"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	rtrListenAddress   = flag.String("rtrListenAddress", "", "Address to listen on for RPKI-to-Router (RTR) connections serving AS0 VRPs for the active flaps (disabled if empty)")
+	rtrRefreshInterval = flag.Duration("rtrRefreshInterval", 3600*time.Second, "Interval in which routers should poll the RTR server, sent as of protocol version 1")
+	rtrRetryInterval   = flag.Duration("rtrRetryInterval", 600*time.Second, "Time routers should wait before retrying a failed poll of the RTR server, sent as of protocol version 1")
+	rtrExpireInterval  = flag.Duration("rtrExpireInterval", 7200*time.Second, "Time after which routers should discard the VRPs if the RTR server cannot be reached, sent as of protocol version 1")
+)
+
+// Interval in which the VRPs are updated from the active flaps
+const updateInterval = 5 * time.Second
+
+type Module struct {
+	name   string
+	logger *slog.Logger
+	cache  *cache
+	timing timing
+}
+
+func (m *Module) Name() string {
+	return m.name
+}
+
+func (m *Module) OnStart() bool {
+	if *rtrListenAddress == "" {
+		return false
+	}
+	m.logger = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{})).With("module", m.Name())
+
+	var err error
+	m.timing, err = timingOf(*rtrRefreshInterval, *rtrRetryInterval, *rtrExpireInterval)
+	if err != nil {
+		m.logger.Error("Invalid RTR timing parameters", "error", err)
+		return false
+	}
+	listener, err := net.Listen("tcp", *rtrListenAddress)
+	if err != nil {
+		m.logger.Error("Failed to start RTR listener", "error", err)
+		return false
+	}
+
+	m.cache = newCache()
+	m.cache.update(activeFlapPrefixes(m.logger), time.Now())
+	sessionID, serial := m.cache.current()
+	m.logger.Info("RTR server started", "listen_address", listener.Addr(), "session_id", sessionID, "serial", serial)
+
+	go m.updateCache()
+	go m.serve(listener)
+	return false
+}
+
+func (m *Module) OnEvent(_ analyze.FlapEvent, _ bool) {}
+
+func init() {
+	monitor.RegisterModule(&Module{
+		name: "mod_rtr",
+	})
+}
+
+// timingOf validates the timing parameters against the ranges of RFC 8210 section 6
+func timingOf(refresh, retry, expire time.Duration) (timing, error) {
+	t := timing{
+		Refresh: uint32(refresh / time.Second),
+		Retry:   uint32(retry / time.Second),
+		Expire:  uint32(expire / time.Second),
+	}
+	if refresh < time.Second || refresh > 86400*time.Second {
+		return t, errors.New("refresh interval must be between 1 second and 1 day")
+	}
+	if retry < time.Second || retry > 7200*time.Second {
+		return t, errors.New("retry interval must be between 1 second and 2 hours")
+	}
+	if expire < 600*time.Second || expire > 172800*time.Second {
+		return t, errors.New("expire interval must be between 10 minutes and 2 days")
+	}
+	if expire <= refresh || expire <= retry {
+		return t, errors.New("expire interval must be larger than the refresh and retry intervals")
+	}
+	return t, nil
+}
+
+// activeFlapPrefixes returns the prefixes of the active flaps, for which AS0 VRPs are served
+func activeFlapPrefixes(logger *slog.Logger) []netip.Prefix {
+	flaps := monitor.GetActiveFlapsSummary()
+	prefixes := make([]netip.Prefix, 0, len(flaps))
+	for _, f := range flaps {
+		prefix, err := netip.ParsePrefix(f.Prefix)
+		if err != nil {
+			logger.Warn("Invalid prefix of active flap", "prefix", f.Prefix, "error", err)
+			continue
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes
+}
+
+func (m *Module) updateCache() {
+	ticker := time.NewTicker(updateInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if m.cache.update(activeFlapPrefixes(m.logger), now) {
+			_, serial := m.cache.current()
+			m.logger.Debug("VRPs updated", "serial", serial)
+		}
+	}
+}
+
+func (m *Module) serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			m.logger.Warn("Failed to accept RTR connection", "error", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		go m.handleConnection(conn)
+	}
+}
+
+// client is a router connected to the RTR server
+type client struct {
+	conn      net.Conn
+	writeLock sync.Mutex
+	// Protocol version of the session, negative until the first query has been received
+	version atomic.Int32
+	// Signals that a Serial Notify should be sent
+	notifyChan chan struct{}
+}
+
+func (cl *client) notify() {
+	select {
+	case cl.notifyChan <- struct{}{}:
+	default:
+	}
+}
+
+func (cl *client) write(b []byte) error {
+	cl.writeLock.Lock()
+	defer cl.writeLock.Unlock()
+	_, err := cl.conn.Write(b)
+	return err
+}
+
+func (m *Module) handleConnection(conn net.Conn) {
+	logger := m.logger.With("remote", conn.RemoteAddr())
+	logger.Info("Router connected")
+	cl := &client{
+		conn:       conn,
+		notifyChan: make(chan struct{}, 1),
+	}
+	cl.version.Store(-1)
+
+	m.cache.addClient(cl)
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		for range cl.notifyChan {
+			version := cl.version.Load()
+			if version < 0 {
+				continue
+			}
+			sessionID, serial := m.cache.current()
+			if err := cl.write(appendSerialNotify(nil, uint8(version), sessionID, serial)); err != nil {
+				logger.Debug("Error sending Serial Notify", "error", err)
+				_ = conn.Close()
+			}
+		}
+	})
+	defer func() {
+		m.cache.removeClient(cl)
+		close(cl.notifyChan)
+		wg.Wait()
+		_ = conn.Close()
+	}()
+
+	err := m.handleQueries(cl, logger)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+		logger.Warn("RTR connection closed", "error", err)
+		return
+	}
+	logger.Info("Router disconnected")
+}
+
+// handleQueries answers the queries of the router until the connection is closed or an error occurs
+func (m *Module) handleQueries(cl *client, logger *slog.Logger) error {
+	for {
+		p, err := readPDU(cl.conn)
+		if err != nil {
+			if p.raw != nil {
+				_ = cl.write(appendErrorReport(nil, uint8(max(cl.version.Load(), 0)), errorCorruptData, p.raw, err.Error()))
+			}
+			return err
+		}
+
+		version := cl.version.Load()
+		if version < 0 {
+			// The router starts with the highest version it supports (RFC 8210 section 7)
+			if p.Version > maxVersion {
+				_ = cl.write(appendErrorReport(nil, maxVersion, errorUnsupportedProtocolVersion, p.raw, ""))
+				return fmt.Errorf("unsupported protocol version %d", p.Version)
+			}
+			if p.Type != pduErrorReport {
+				version = int32(p.Version)
+				cl.version.Store(version)
+				logger.Info("Protocol version negotiated", "version", version)
+			}
+		} else if int32(p.Version) != version {
+			_ = cl.write(appendErrorReport(nil, uint8(version), errorUnexpectedProtocolVersion, p.raw, ""))
+			return fmt.Errorf("unexpected protocol version %d, negotiated version %d", p.Version, version)
+		}
+
+		var response []byte
+		switch p.Type {
+		case pduResetQuery:
+			if len(p.Body) != 0 {
+				_ = cl.write(appendErrorReport(nil, uint8(version), errorCorruptData, p.raw, "invalid Reset Query length"))
+				return errors.New("invalid Reset Query length")
+			}
+			sessionID, _ := m.cache.current()
+			prefixes, serial := m.cache.snapshot()
+			logger.Debug("Reset Query", "serial", serial, "vrps", len(prefixes))
+			response = appendCacheResponse(response, uint8(version), sessionID)
+			for _, prefix := range prefixes {
+				response = appendPrefix(response, uint8(version), prefix, 0, true)
+			}
+			response = appendEndOfData(response, uint8(version), sessionID, serial, m.timing)
+		case pduSerialQuery:
+			since, err := p.serial()
+			if err != nil {
+				_ = cl.write(appendErrorReport(nil, uint8(version), errorCorruptData, p.raw, err.Error()))
+				return err
+			}
+			sessionID, _ := m.cache.current()
+			announced, withdrawn, serial, ok := m.cache.changesSince(since)
+			if p.Session != sessionID || !ok {
+				// The router has to start over with a Reset Query
+				logger.Debug("Serial Query of unknown serial", "session_id", p.Session, "serial", since)
+				response = appendCacheReset(response, uint8(version))
+				break
+			}
+			logger.Debug("Serial Query", "since", since, "serial", serial, "announced", len(announced), "withdrawn", len(withdrawn))
+			response = appendCacheResponse(response, uint8(version), sessionID)
+			for _, prefix := range withdrawn {
+				response = appendPrefix(response, uint8(version), prefix, 0, false)
+			}
+			for _, prefix := range announced {
+				response = appendPrefix(response, uint8(version), prefix, 0, true)
+			}
+			response = appendEndOfData(response, uint8(version), sessionID, serial, m.timing)
+		case pduErrorReport:
+			// Error reports are never answered
+			code, text := p.errorReport()
+			return fmt.Errorf("router reported an error: %s: %q", code, text)
+		case pduSerialNotify, pduCacheResponse, pduIPv4Prefix, pduIPv6Prefix, pduEndOfData, pduCacheReset, pduRouterKey, pduASPA:
+			// Only sent by caches
+			_ = cl.write(appendErrorReport(nil, uint8(version), errorInvalidRequest, p.raw, ""))
+			return fmt.Errorf("unexpected PDU of type %d", p.Type)
+		default:
+			_ = cl.write(appendErrorReport(nil, uint8(version), errorUnsupportedPDUType, p.raw, ""))
+			return fmt.Errorf("unsupported PDU of type %d", p.Type)
+		}
+		if err := cl.write(response); err != nil {
+			return err
+		}
+	}
+}
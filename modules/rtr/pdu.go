@@ -0,0 +1,201 @@
+//go:build !disable_mod_rtr
+
+package rtr
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+)
+
+// Highest protocol version supported by the cache. Versions 0 (RFC 6810), 1 (RFC 8210)
+// and 2 (draft-ietf-sidrops-8210bis) only differ in the PDUs relevant to VRPs by the End of Data PDU.
+const maxVersion = 2
+
+type pduType uint8
+
+const (
+	pduSerialNotify  pduType = 0
+	pduSerialQuery   pduType = 1
+	pduResetQuery    pduType = 2
+	pduCacheResponse pduType = 3
+	pduIPv4Prefix    pduType = 4
+	pduIPv6Prefix    pduType = 6
+	pduEndOfData     pduType = 7
+	pduCacheReset    pduType = 8
+	pduRouterKey     pduType = 9
+	pduErrorReport   pduType = 10
+	pduASPA          pduType = 11
+)
+
+type errorCode uint16
+
+const (
+	errorCorruptData                errorCode = 0
+	errorInternalError              errorCode = 1
+	errorNoDataAvailable            errorCode = 2
+	errorInvalidRequest             errorCode = 3
+	errorUnsupportedProtocolVersion errorCode = 4
+	errorUnsupportedPDUType         errorCode = 5
+	errorWithdrawalOfUnknownRecord  errorCode = 6
+	errorDuplicateAnnouncement      errorCode = 7
+	errorUnexpectedProtocolVersion  errorCode = 8
+)
+
+func (c errorCode) String() string {
+	switch c {
+	case errorCorruptData:
+		return "Corrupt Data"
+	case errorInternalError:
+		return "Internal Error"
+	case errorNoDataAvailable:
+		return "No Data Available"
+	case errorInvalidRequest:
+		return "Invalid Request"
+	case errorUnsupportedProtocolVersion:
+		return "Unsupported Protocol Version"
+	case errorUnsupportedPDUType:
+		return "Unsupported PDU Type"
+	case errorWithdrawalOfUnknownRecord:
+		return "Withdrawal of Unknown Record"
+	case errorDuplicateAnnouncement:
+		return "Duplicate Announcement Received"
+	case errorUnexpectedProtocolVersion:
+		return "Unexpected Protocol Version"
+	}
+	return fmt.Sprintf("unknown (%d)", uint16(c))
+}
+
+const (
+	headerLength = 8
+	// Upper bound of the PDUs accepted from routers, which only send queries and error reports
+	maxPDULength = 1 << 16
+)
+
+// Flag of prefix PDUs announcing instead of withdrawing the prefix
+const flagAnnouncement = 1
+
+// pdu is a PDU received from a router
+type pdu struct {
+	Version uint8
+	Type    pduType
+	// Session ID or error code, depending on the type
+	Session uint16
+	Body    []byte
+	// Complete PDU, used to encapsulate it in error reports
+	raw []byte
+}
+
+// Timing parameters sent in End of Data PDUs as of version 1
+type timing struct {
+	Refresh uint32
+	Retry   uint32
+	Expire  uint32
+}
+
+func readPDU(r io.Reader) (pdu, error) {
+	header := make([]byte, headerLength)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return pdu{}, err
+	}
+	length := binary.BigEndian.Uint32(header[4:])
+	if length < headerLength || length > maxPDULength {
+		return pdu{raw: header}, fmt.Errorf("invalid PDU length %d", length)
+	}
+	raw := make([]byte, length)
+	copy(raw, header)
+	if _, err := io.ReadFull(r, raw[headerLength:]); err != nil {
+		return pdu{}, err
+	}
+	return pdu{
+		Version: raw[0],
+		Type:    pduType(raw[1]),
+		Session: binary.BigEndian.Uint16(raw[2:]),
+		Body:    raw[headerLength:],
+		raw:     raw,
+	}, nil
+}
+
+// serial returns the serial number of a Serial Query PDU
+func (p pdu) serial() (uint32, error) {
+	if len(p.Body) != 4 {
+		return 0, errors.New("invalid Serial Query length")
+	}
+	return binary.BigEndian.Uint32(p.Body), nil
+}
+
+// errorReport returns the error code and text of an Error Report PDU
+func (p pdu) errorReport() (errorCode, string) {
+	b := p.Body
+	if len(b) < 4 {
+		return errorCode(p.Session), ""
+	}
+	pduLength := binary.BigEndian.Uint32(b)
+	if uint64(pduLength)+8 > uint64(len(b)) {
+		return errorCode(p.Session), ""
+	}
+	b = b[4+pduLength:]
+	textLength := binary.BigEndian.Uint32(b)
+	if uint64(textLength) > uint64(len(b)-4) {
+		return errorCode(p.Session), ""
+	}
+	return errorCode(p.Session), string(b[4 : 4+textLength])
+}
+
+func appendHeader(b []byte, version uint8, t pduType, session uint16, length int) []byte {
+	b = append(b, version, byte(t))
+	b = binary.BigEndian.AppendUint16(b, session)
+	return binary.BigEndian.AppendUint32(b, uint32(length))
+}
+
+func appendSerialNotify(b []byte, version uint8, session uint16, serial uint32) []byte {
+	b = appendHeader(b, version, pduSerialNotify, session, 12)
+	return binary.BigEndian.AppendUint32(b, serial)
+}
+
+func appendCacheResponse(b []byte, version uint8, session uint16) []byte {
+	return appendHeader(b, version, pduCacheResponse, session, headerLength)
+}
+
+func appendCacheReset(b []byte, version uint8) []byte {
+	return appendHeader(b, version, pduCacheReset, 0, headerLength)
+}
+
+// appendPrefix appends an IPv4 or IPv6 Prefix PDU of a VRP covering the prefix and all its more-specifics
+func appendPrefix(b []byte, version uint8, prefix netip.Prefix, asn uint32, announce bool) []byte {
+	t, length := pduIPv4Prefix, 20
+	if prefix.Addr().Is6() {
+		t, length = pduIPv6Prefix, 32
+	}
+	var flags byte
+	if announce {
+		flags = flagAnnouncement
+	}
+	b = appendHeader(b, version, t, 0, length)
+	b = append(b, flags, uint8(prefix.Bits()), uint8(prefix.Addr().BitLen()), 0)
+	b = append(b, prefix.Masked().Addr().AsSlice()...)
+	return binary.BigEndian.AppendUint32(b, asn)
+}
+
+func appendEndOfData(b []byte, version uint8, session uint16, serial uint32, t timing) []byte {
+	if version == 0 {
+		b = appendHeader(b, version, pduEndOfData, session, 12)
+		return binary.BigEndian.AppendUint32(b, serial)
+	}
+	b = appendHeader(b, version, pduEndOfData, session, 24)
+	b = binary.BigEndian.AppendUint32(b, serial)
+	b = binary.BigEndian.AppendUint32(b, t.Refresh)
+	b = binary.BigEndian.AppendUint32(b, t.Retry)
+	return binary.BigEndian.AppendUint32(b, t.Expire)
+}
+
+// appendErrorReport appends an Error Report PDU encapsulating the erroneous PDU, which may be nil
+func appendErrorReport(b []byte, version uint8, code errorCode, erroneous []byte, text string) []byte {
+	b = appendHeader(b, version, pduErrorReport, uint16(code), headerLength+8+len(erroneous)+len(text))
+	b = binary.BigEndian.AppendUint32(b, uint32(len(erroneous)))
+	b = append(b, erroneous...)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(text)))
+	return append(b, text...)
+}
@@ -13,8 +13,9 @@ import (
 )
 
 var (
-	scriptFileStart = flag.String("detectionScriptStart", "", "Optional path to script to run when a flap event is detected (start)")
-	scriptFileEnd   = flag.String("detectionScriptEnd", "", "Optional path to script to run when a flap event is detected (end)")
+	scriptFileStart   = flag.String("detectionScriptStart", "", "Optional path to script to run when a flap event is detected (start)")
+	scriptFileEnd     = flag.String("detectionScriptEnd", "", "Optional path to script to run when a flap event is detected (end)")
+	scriptFileSession = flag.String("sessionScript", "", "Optional path to script to run when a BGP session is established or closed")
 )
 
 type Module struct {
@@ -27,7 +28,7 @@ func (m *Module) Name() string {
 }
 
 func (m *Module) OnStart() bool {
-	if *scriptFileStart == "" && *scriptFileEnd == "" {
+	if *scriptFileStart == "" && *scriptFileEnd == "" && *scriptFileSession == "" {
 		return false
 	}
 
@@ -53,7 +54,24 @@ func (m *Module) runScript(path string, f analyze.FlapEvent) {
 		l.Error("Marshalling flap information failed", "error", err.Error())
 		return
 	}
-	err = exec.Command(path, string(eventJSON)).Run()
+	m.exec(l, path, eventJSON)
+}
+
+func (m *Module) OnSessionEvent(e monitor.SessionEvent) {
+	if *scriptFileSession == "" {
+		return
+	}
+	l := m.logger.With("path", *scriptFileSession, "remote", e.Session.Remote)
+	eventJSON, err := json.Marshal(e)
+	if err != nil {
+		l.Error("Marshalling session event failed", "error", err.Error())
+		return
+	}
+	m.exec(l, *scriptFileSession, eventJSON)
+}
+
+func (m *Module) exec(l *slog.Logger, path string, eventJSON []byte) {
+	err := exec.Command(path, string(eventJSON)).Run()
 	if err != nil {
 		l.Error("Error executing script", "error", err.Error())
 	}
@@ -18,6 +18,8 @@ import (
 var (
 	webhookUrlsStart    = stringSliceFlag("webhookUrlStart", "Optional webhook URL for when a flap event is detected (start); can be specified multiple times")
 	webhookUrlsEnd      = stringSliceFlag("webhookUrlEnd", "Optional webhook URL for when a flap event is detected (end); can be specified multiple times")
+	webhookUrlsLeak     = stringSliceFlag("webhookUrlLeak", "Optional webhook URL for when a flap event involves possible route leaks; can be specified multiple times")
+	webhookUrlsSession  = stringSliceFlag("webhookUrlSession", "Optional webhook URL for when a BGP session is established or closed; can be specified multiple times")
 	webhookTimeout      = flag.Duration("webhookTimeout", 10*time.Second, "Timeout for webhook HTTP requests")
 	webhookInstanceName = flag.String("webhookInstanceName", "", "Optional webhook instance name to set as X-Instance-Name")
 )
@@ -42,7 +44,7 @@ func (m *Module) Name() string {
 }
 
 func (m *Module) OnStart() bool {
-	if len(*webhookUrlsStart) == 0 && len(*webhookUrlsEnd) == 0 {
+	if len(*webhookUrlsStart) == 0 && len(*webhookUrlsEnd) == 0 && len(*webhookUrlsLeak) == 0 && len(*webhookUrlsSession) == 0 {
 		return false
 	}
 
@@ -63,6 +65,26 @@ func (m *Module) OnEvent(f analyze.FlapEvent, isStart bool) {
 	}
 }
 
+func (m *Module) OnRouteLeak(f analyze.FlapEvent) {
+	for _, url := range *webhookUrlsLeak {
+		m.callWebHook(url, f)
+	}
+}
+
+func (m *Module) OnSessionEvent(e monitor.SessionEvent) {
+	if len(*webhookUrlsSession) == 0 {
+		return
+	}
+	eventJSON, err := json.Marshal(e)
+	if err != nil {
+		m.logger.Error("Marshalling session event failed", "error", err.Error())
+		return
+	}
+	for _, url := range *webhookUrlsSession {
+		m.post(m.logger.With("url", url, "remote", e.Session.Remote), url, eventJSON)
+	}
+}
+
 func (m *Module) callWebHook(URL string, f analyze.FlapEvent) {
 	if URL == "" {
 		return
@@ -73,7 +95,10 @@ func (m *Module) callWebHook(URL string, f analyze.FlapEvent) {
 		l.Error("Marshalling flap information failed", "error", err.Error())
 		return
 	}
+	m.post(l, URL, eventJSON)
+}
 
+func (m *Module) post(l *slog.Logger, URL string, eventJSON []byte) {
 	ctx, cancel := context.WithTimeout(context.Background(), *webhookTimeout)
 	defer cancel()
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, URL, bytes.NewReader(eventJSON))
@@ -0,0 +1,55 @@
+package monitor
+
+import (
+	"FlapAlerted/bgp/session"
+	"FlapAlerted/bgp/table"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// Maximum number of routes returned per session by a looking glass query
+const MaxLookupRoutes = 1000
+
+type SessionRoutes = session.Routes
+
+type LookupResult struct {
+	Prefix   netip.Prefix
+	Match    table.MatchType
+	Sessions []SessionRoutes
+}
+
+// LookupRoutes returns the current routes of all sessions matching the query, which is a prefix or an address.
+// The match is one of exact, longest, more-specific or less-specific. If it is empty, prefixes are matched exactly
+// and addresses by the longest matching prefix.
+func LookupRoutes(query string, match string) (LookupResult, error) {
+	var prefix netip.Prefix
+	matchType := table.MatchExact
+	if strings.Contains(query, "/") {
+		p, err := netip.ParsePrefix(query)
+		if err != nil {
+			return LookupResult{}, fmt.Errorf("invalid prefix: %w", err)
+		}
+		prefix = p.Masked()
+	} else {
+		addr, err := netip.ParseAddr(query)
+		if err != nil {
+			return LookupResult{}, fmt.Errorf("invalid address: %w", err)
+		}
+		addr = addr.Unmap().WithZone("")
+		prefix = netip.PrefixFrom(addr, addr.BitLen())
+		matchType = table.MatchLongest
+	}
+	if match != "" {
+		var err error
+		matchType, err = table.ParseMatchType(match)
+		if err != nil {
+			return LookupResult{}, err
+		}
+	}
+	return LookupResult{
+		Prefix:   prefix,
+		Match:    matchType,
+		Sessions: session.LookupRoutes(prefix, matchType, MaxLookupRoutes),
+	}, nil
+}
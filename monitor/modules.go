@@ -2,6 +2,7 @@ package monitor
 
 import (
 	"FlapAlerted/analyze"
+	"FlapAlerted/bgp/session"
 	"FlapAlerted/config"
 	"log/slog"
 	"net/netip"
@@ -29,24 +30,66 @@ type Module interface {
 	OnEvent(event analyze.FlapEvent, isStart bool)
 }
 
+// RouteLeakHandler can optionally be implemented by a Module to be notified of flap events
+// involving paths classified as possible route leaks (RFC 9234).
+type RouteLeakHandler interface {
+	// OnRouteLeak is called once per flap event, after its start.
+	// Runs inside a worker goroutine.
+	OnRouteLeak(event analyze.FlapEvent)
+}
+
+type SessionEvent = session.Event
+
+const (
+	SessionEstablished        = session.EventEstablished
+	SessionClosed             = session.EventClosed
+	SessionImportLimit        = session.EventImportLimit
+	SessionImportLimitWarning = session.EventImportLimitWarning
+)
+
+// SessionEventHandler can optionally be implemented by a Module to be notified when BGP sessions
+// are established or closed, including sessions closed for exceeding their import limit.
+type SessionEventHandler interface {
+	// OnSessionEvent is called for every session event.
+	// Runs inside a worker goroutine.
+	OnSessionEvent(event SessionEvent)
+}
+
 type moduleWorker struct {
 	impl      Module
 	eventChan chan []analyze.FlapEventNotification
+	// Nil if the module does not handle session events
+	sessionEventChan chan SessionEvent
 }
 
 func (w *moduleWorker) run() {
 	for {
-		events, ok := <-w.eventChan
-		if !ok {
-			return
+		var events []analyze.FlapEventNotification
+		select {
+		case e, ok := <-w.sessionEventChan:
+			if !ok {
+				return
+			}
+			w.impl.(SessionEventHandler).OnSessionEvent(e)
+			continue
+		case events = <-w.eventChan:
+			if events == nil {
+				return
+			}
 		}
 		for _, e := range events {
+			if e.IsRouteLeak {
+				if h, ok := w.impl.(RouteLeakHandler); ok {
+					h.OnRouteLeak(e.Event)
+				}
+				continue
+			}
 			w.impl.OnEvent(e.Event, e.IsStart)
 		}
 	}
 }
 
-func notificationHandler(c <-chan []analyze.FlapEventNotification) {
+func notificationHandler(c <-chan []analyze.FlapEventNotification, sessionEvents <-chan SessionEvent) {
 	modulesStarted.Store(true)
 
 	workerList := make([]*moduleWorker, 0)
@@ -57,6 +100,9 @@ func notificationHandler(c <-chan []analyze.FlapEventNotification) {
 				impl:      m,
 				eventChan: make(chan []analyze.FlapEventNotification, 3),
 			}
+			if _, ok := m.(SessionEventHandler); ok {
+				worker.sessionEventChan = make(chan SessionEvent, 10)
+			}
 			go worker.run()
 			workerList = append(workerList, worker)
 		}
@@ -66,13 +112,32 @@ func notificationHandler(c <-chan []analyze.FlapEventNotification) {
 		// Cleanup
 		for _, w := range workerList {
 			close(w.eventChan)
+			if w.sessionEventChan != nil {
+				close(w.sessionEventChan)
+			}
 		}
 	}()
 
 	for {
-		events, ok := <-c
-		if !ok {
-			return
+		var events []analyze.FlapEventNotification
+		select {
+		case e := <-sessionEvents:
+			for _, w := range workerList {
+				if w.sessionEventChan == nil {
+					continue
+				}
+				select {
+				case w.sessionEventChan <- e:
+				default:
+					slog.Warn("Modules cannot keep up with session events", "module", w.impl.Name())
+				}
+			}
+			continue
+		case batch, ok := <-c:
+			if !ok {
+				return
+			}
+			events = batch
 		}
 		for _, w := range workerList {
 			select {
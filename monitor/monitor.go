@@ -3,10 +3,15 @@ package monitor
 import (
 	"FlapAlerted/analyze"
 	"FlapAlerted/bgp"
+	"FlapAlerted/bgp/mrt"
+	"FlapAlerted/bgp/session"
+	"FlapAlerted/bgp/table"
 	"FlapAlerted/config"
 	"context"
 	"fmt"
+	"net/netip"
 	"sync"
+	"time"
 )
 
 var (
@@ -27,11 +32,25 @@ func StartMonitoring(ctx context.Context, conf config.UserConfig) error {
 	var wg sync.WaitGroup
 	defer wg.Wait()
 
-	pathChangeChan, err := bgp.StartBGP(ctx, &wg, config.GlobalConf.BgpListenAddress)
-	if err != nil {
-		return fmt.Errorf("failed to start BGP: %w", err)
+	var pathChangeChan <-chan table.PathChange
+	var clock <-chan time.Time
+	var err error
+	if len(config.GlobalConf.MrtReplayFiles) != 0 {
+		pathChangeChan, clock, err = mrt.StartReplay(ctx, &wg, config.GlobalConf.MrtReplayFiles, config.GlobalConf.MrtReplaySpeed, analyze.Interval)
+		if err != nil {
+			return fmt.Errorf("failed to start MRT replay: %w", err)
+		}
+	} else {
+		var announce func() []netip.Prefix
+		if config.GlobalConf.AnnounceEnabled() {
+			announce = analyze.GetActiveFlapPrefixes
+		}
+		pathChangeChan, err = bgp.StartBGP(ctx, &wg, config.GlobalConf.BgpListenAddress, announce)
+		if err != nil {
+			return fmt.Errorf("failed to start BGP: %w", err)
+		}
 	}
-	userPathChangeChan, notificationChannel := analyze.RecordPathChanges(pathChangeChan)
+	userPathChangeChan, notificationChannel := analyze.RecordPathChanges(pathChangeChan, clock)
 
 	wg.Go(func() {
 		analyze.RecordUserDefinedMonitors(userPathChangeChan)
@@ -40,7 +59,7 @@ func StartMonitoring(ctx context.Context, conf config.UserConfig) error {
 		statTracker(ctx)
 	})
 	wg.Go(func() {
-		notificationHandler(notificationChannel)
+		notificationHandler(notificationChannel, session.Events())
 	})
 	<-ctx.Done()
 	return ctx.Err()
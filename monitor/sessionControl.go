@@ -0,0 +1,32 @@
+package monitor
+
+import (
+	"FlapAlerted/bgp/notification"
+	"FlapAlerted/bgp/session"
+	"net/netip"
+	"time"
+)
+
+// Maximum length in bytes of the message sent when resetting a session
+const MaxShutdownCommunicationLength = notification.MaxShutdownCommunicationLength
+
+// ResetSessions closes the BGP sessions with the remote address with an Administrative Reset.
+// Returns the number of sessions that have been reset.
+func ResetSessions(remote netip.Addr, message string) int {
+	return session.ResetSessions(remote, message)
+}
+
+type SessionBlock = session.Block
+
+// BlockRemote prevents the remote address from establishing BGP sessions for the given duration
+func BlockRemote(remote netip.Addr, duration time.Duration) SessionBlock {
+	return session.BlockRemote(remote, duration)
+}
+
+func UnblockRemote(remote netip.Addr) bool {
+	return session.UnblockRemote(remote)
+}
+
+func GetBlockedRemotes() []SessionBlock {
+	return session.GetBlockedRemotes()
+}
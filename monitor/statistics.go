@@ -2,10 +2,12 @@ package monitor
 
 import (
 	"FlapAlerted/analyze"
+	"FlapAlerted/bgp/common"
 	"FlapAlerted/bgp/session"
 	"cmp"
 	"context"
 	"math"
+	"net/netip"
 	"slices"
 	"sort"
 	"strconv"
@@ -42,12 +44,29 @@ func GetSessionInfoJson() (string, error) {
 	return session.GetSessionInfoJson()
 }
 
+func GetSessionHistoryJson() (string, error) {
+	return session.GetSessionHistoryJson()
+}
+
+type SessionInfo = session.Info
+
+type SessionID = common.SessionID
+
+func GetSessions() []SessionInfo {
+	return session.GetSessions()
+}
+
 type Metric struct {
 	ActiveFlapCount                int
 	ActiveFlapTotalPathChangeCount uint64
 	TotalPathChangeCount           uint64
 	AverageRouteChanges90          string
 	Sessions                       int
+	// Distinct AS paths shared by all sessions and path histories, and their memory usage in bytes
+	InternedAsPaths     int64
+	InternedAsPathBytes int64
+	AsPathInternLookups uint64
+	AsPathInternHits    uint64
 }
 
 func GetMetric() Metric {
@@ -62,6 +81,7 @@ func GetMetric() Metric {
 	}
 	avg := GetAverageRouteChanges90()
 	avgStr := strconv.FormatFloat(avg, 'f', 2, 64)
+	pool := common.GetAsPathPoolStats()
 
 	return Metric{
 		ActiveFlapCount:                activeFlapCount,
@@ -69,6 +89,10 @@ func GetMetric() Metric {
 		TotalPathChangeCount:           pathChangeCount,
 		AverageRouteChanges90:          avgStr,
 		Sessions:                       session.GetSessionCount(),
+		InternedAsPaths:                pool.Paths,
+		InternedAsPathBytes:            pool.Bytes,
+		AsPathInternLookups:            pool.Lookups,
+		AsPathInternHits:               pool.Hits,
 	}
 }
 
@@ -86,6 +110,18 @@ type FlapSummary struct {
 	FirstSeen  int64
 	RateSec    int
 	TotalCount uint64
+	// Number of sessions the path changes were received on
+	Sessions int
+}
+
+func summaryOf(f analyze.FlapEvent) FlapSummary {
+	return FlapSummary{
+		Prefix:     f.Prefix.String(),
+		FirstSeen:  f.FirstSeen,
+		RateSec:    f.RateSec,
+		TotalCount: f.TotalPathChanges,
+		Sessions:   len(f.Sessions),
+	}
 }
 
 type PeerSummary struct {
@@ -150,12 +186,7 @@ func statTracker(ctx context.Context) {
 
 		jsFlapList := make([]FlapSummary, len(aFlap))
 		for i, f := range aFlap {
-			jsFlapList[i] = FlapSummary{
-				Prefix:     f.Prefix.String(),
-				FirstSeen:  f.FirstSeen,
-				RateSec:    f.RateSec,
-				TotalCount: f.TotalPathChanges,
-			}
+			jsFlapList[i] = summaryOf(f)
 		}
 
 		jsPeerList := make([]PeerSummary, len(aPeer))
@@ -235,6 +266,16 @@ func GetActiveFlapsSummary() []FlapSummary {
 	return *l
 }
 
+// GetActiveFlapsWithin returns the active flaps of the prefix and its more-specifics, sorted by prefix
+func GetActiveFlapsWithin(prefix netip.Prefix) []FlapSummary {
+	aFlap := analyze.GetActiveFlapsWithin(prefix)
+	summaries := make([]FlapSummary, len(aFlap))
+	for i, f := range aFlap {
+		summaries[i] = summaryOf(f)
+	}
+	return summaries
+}
+
 func GetActivePeersSummary() []PeerSummary {
 	p := lastPeerSummaryList.Load()
 	if p == nil {